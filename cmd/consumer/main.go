@@ -3,16 +3,21 @@ package main
 import (
 	"L0/migrations"
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"L0/internal/cache"
 	"L0/internal/config"
+	"L0/internal/consumer"
+	"L0/internal/health"
+	"L0/internal/invalidation"
 	"L0/internal/logger"
 	"L0/internal/repository"
 	"L0/internal/service"
@@ -21,12 +26,12 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-func connectToDB(dsn string, maxAttempts int) (*repository.PostgresRepository, error) {
+func connectToDB(dsn string, timeouts repository.Timeouts, maxAttempts int) (*repository.PostgresRepository, error) {
 	var repo *repository.PostgresRepository
 	var err error
 
 	for i := 0; i < maxAttempts; i++ {
-		repo, err = repository.New(dsn)
+		repo, err = repository.New(dsn, timeouts)
 		if err == nil {
 			return repo, nil
 		}
@@ -48,7 +53,11 @@ func main() {
 	}
 	defer mongoLogger.Close()
 
-	repo, err := connectToDB(cfg.PostgresDSN, 5)
+	repo, err := connectToDB(cfg.PostgresDSN, repository.Timeouts{
+		Read:   cfg.DBReadTimeout,
+		Write:  cfg.DBWriteTimeout,
+		Stream: cfg.DBStreamTimeout,
+	}, 5)
 	if err != nil {
 		mongoLogger.Log("ERROR", "consumer", "Failed to connect to database: "+err.Error())
 		log.Fatal("Failed to connect to database:", err)
@@ -60,19 +69,120 @@ func main() {
 		log.Fatal("Failed to apply migrations:", err)
 	}
 
-	cache := cache.New(cfg.CacheMaxSize, time.Duration(cfg.CacheTTLMinutes)*time.Minute)
+	evictionPolicy, err := cache.PolicyByName(cfg.CacheEviction)
+	if err != nil {
+		mongoLogger.Log("ERROR", "consumer", "Invalid cache configuration: "+err.Error())
+		log.Fatal("Invalid cache configuration:", err)
+	}
+
+	sizeEstimator, err := cache.NewSizeEstimator(cfg.CacheSizeEstimator)
+	if err != nil {
+		mongoLogger.Log("ERROR", "consumer", "Invalid cache configuration: "+err.Error())
+		log.Fatal("Invalid cache configuration:", err)
+	}
+
+	cache := cache.New(cfg.CacheMaxSize, time.Duration(cfg.CacheTTLMinutes)*time.Minute,
+		cache.WithShards(cfg.CacheShards),
+		cache.WithEvictionPolicy(evictionPolicy),
+		cache.WithSizeEstimator(sizeEstimator),
+		cache.WithNegativeCache(cfg.CacheNegativeTTL, cfg.CacheNegativeMax),
+	)
 	defer cache.Stop()
 
-	orderService := service.NewOrderService(repo, cache)
+	consistencyRules, err := service.ParseConsistencyRules(cfg.ConsistencyRules)
+	if err != nil {
+		mongoLogger.Log("ERROR", "consumer", "Invalid validation configuration: "+err.Error())
+		log.Fatal("Invalid validation configuration:", err)
+	}
+
+	fieldRules, err := service.CompileFieldRules(cfg.ValidationRules)
+	if err != nil {
+		mongoLogger.Log("ERROR", "consumer", "Invalid validation configuration: "+err.Error())
+		log.Fatal("Invalid validation configuration:", err)
+	}
+
+	orderService := service.NewOrderService(repo, cache).
+		WithConsistencyRules(consistencyRules).
+		WithFieldRules(fieldRules)
+
+	probe := health.NewProbe(repo)
 
-	if err := orderService.RestoreCacheFromDB(); err != nil {
-		mongoLogger.Log("ERROR", "consumer", "Failed to restore cache: "+err.Error())
-		log.Fatal("Failed to restore cache:", err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.ValidationReload > 0 {
+		go orderService.WatchFieldRules(ctx, cfg.ValidationFile, cfg.ValidationReload, func(err error) {
+			if err != nil {
+				mongoLogger.Log("ERROR", "consumer", "Failed to reload validation rules: "+err.Error())
+				log.Printf("Failed to reload validation rules: %v", err)
+				return
+			}
+			mongoLogger.Log("INFO", "consumer", "Validation rules reloaded")
+			log.Println("Validation rules reloaded")
+		})
 	}
 
+	// Apply the order changes made by other processes, and by this one,
+	// to the cache.
+	invalidations := invalidation.NewListener(cfg.PostgresDSN, orderService, mongoLogger, "consumer").
+		WithBatching(cfg.InvalidationBatch, cfg.InvalidationWait)
+	go func() {
+		if err := invalidations.Run(ctx); err != nil {
+			mongoLogger.Log("ERROR", "consumer", "Cache invalidation stopped: "+err.Error())
+			log.Printf("Cache invalidation stopped: %v", err)
+		}
+	}()
+
+	var snapshotPath string
+	if cfg.CacheSnapshotDir != "" {
+		if err := os.MkdirAll(cfg.CacheSnapshotDir, 0o755); err != nil {
+			mongoLogger.Log("ERROR", "consumer", "Failed to create cache snapshot directory: "+err.Error())
+			log.Fatal("Failed to create cache snapshot directory:", err)
+		}
+		snapshotPath = filepath.Join(cfg.CacheSnapshotDir, "consumer-cache.snapshot")
+	}
+	snapshotsDone := make(chan struct{})
+
+	// Fill the cache in the background, from the snapshot if there is a
+	// usable one, otherwise from the database. The service is live
+	// meanwhile but reports ready only once the cache is filled.
+	go func() {
+		defer close(snapshotsDone)
+
+		loaded := false
+		if snapshotPath != "" {
+			err := orderService.LoadCacheSnapshot(ctx, snapshotPath)
+			switch {
+			case err == nil:
+				loaded = true
+			case !errors.Is(err, os.ErrNotExist):
+				mongoLogger.Log("WARN", "consumer", "Ignoring cache snapshot: "+err.Error())
+				log.Printf("Ignoring cache snapshot: %v", err)
+			}
+		}
+		if !loaded {
+			err := orderService.WarmUpCache(ctx, service.WarmUpOptions{
+				MaxOrders: cfg.CacheWarmUpMax,
+				HotSet:    cfg.CacheWarmUpHotSet,
+			})
+			if err != nil {
+				mongoLogger.Log("ERROR", "consumer", "Failed to warm up cache: "+err.Error())
+				log.Printf("Failed to warm up cache: %v", err)
+			}
+		}
+		probe.SetReady(true)
+
+		if snapshotPath != "" {
+			orderService.RunCacheSnapshots(ctx, snapshotPath, cfg.CacheSnapshotEvery, func(err error) {
+				mongoLogger.Log("ERROR", "consumer", "Failed to save cache snapshot: "+err.Error())
+				log.Printf("Failed to save cache snapshot: %v", err)
+			})
+		}
+	}()
+
 	go func() {
 		healthHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if err := repo.Ping(); err != nil {
+			if err := repo.Ping(r.Context()); err != nil {
 				http.Error(w, "Database not connected", http.StatusServiceUnavailable)
 				return
 			}
@@ -80,11 +190,15 @@ func main() {
 			w.Write([]byte("OK"))
 		})
 
-		http.Handle("/health", healthHandler)
-		http.Handle("/metrics", promhttp.Handler())
+		// The pprof handlers stay on the default mux, served on :6061.
+		mux := http.NewServeMux()
+		mux.Handle("/health", healthHandler)
+		mux.HandleFunc("/health/live", probe.LiveHandler())
+		mux.HandleFunc("/health/ready", probe.ReadyHandler())
+		mux.Handle("/metrics", promhttp.Handler())
 
 		log.Println("Metrics server started on :8082")
-		http.ListenAndServe(":8082", nil)
+		http.ListenAndServe(":8082", mux)
 	}()
 
 	go func() {
@@ -92,9 +206,6 @@ func main() {
 		log.Println(http.ListenAndServe(":6061", nil))
 	}()
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
-
 	var reader *kafka.Reader
 	for i := 0; i < 10; i++ {
 		testReader := kafka.NewReader(kafka.ReaderConfig{
@@ -122,29 +233,28 @@ func main() {
 	}
 	defer reader.Close()
 
-	log.Println("Consumer started")
-	mongoLogger.Log("INFO", "consumer", "Consumer started")
+	dlqWriter := &kafka.Writer{
+		Addr:                   kafka.TCP(cfg.KafkaBrokers...),
+		Topic:                  cfg.KafkaDLQTopic,
+		Balancer:               &kafka.Hash{},
+		AllowAutoTopicCreation: true,
+	}
+	defer dlqWriter.Close()
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Shutting down consumer...")
-			return
-		default:
-			msg, err := reader.ReadMessage(ctx)
-			if err != nil {
-				mongoLogger.Log("ERROR", "consumer", "Error reading message: "+err.Error())
-				log.Println("Error reading message:", err)
-				continue
-			}
+	orderConsumer := consumer.New(reader, dlqWriter, orderService, consumer.RetryPolicy{
+		MaxAttempts:      cfg.KafkaMaxAttempts,
+		InitialBackoff:   cfg.KafkaRetryBackoff,
+		MaxBackoff:       cfg.KafkaRetryMaxDelay,
+		IsRetryable:      service.IsTransient,
+		IsAlreadyApplied: service.IsAlreadyApplied,
+		ValidationErrors: service.EncodeViolations,
+	}, mongoLogger).WithBatching(orderService, cfg.KafkaBatchSize, cfg.KafkaBatchTimeout)
 
-			if err := orderService.ProcessOrderMessage(msg.Value); err != nil {
-				mongoLogger.Log("ERROR", "consumer", "Error processing message: "+err.Error())
-				log.Println("Error processing message:", err)
-				continue
-			}
+	log.Println("Consumer started")
+	mongoLogger.Log("INFO", "consumer", "Consumer started")
 
-			log.Printf("Order %s processed successfully", string(msg.Key))
-		}
-	}
+	orderConsumer.Run(ctx)
+	log.Println("Shutting down consumer...")
+	stop()
+	<-snapshotsDone
 }
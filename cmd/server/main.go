@@ -1,19 +1,27 @@
 package main
 
 import (
-	models "L0/internal/model"
 	"L0/migrations"
-	"github.com/goccy/go-json"
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
-	"strings"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
+	"L0/internal/api"
 	"L0/internal/cache"
 	"L0/internal/config"
+	"L0/internal/health"
+	"L0/internal/invalidation"
 	"L0/internal/logger"
 	"L0/internal/repository"
+	"L0/internal/server"
 	"L0/internal/service"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -54,7 +62,11 @@ func main() {
 	var repo *repository.PostgresRepository
 	maxDBAttempts := 5
 	for i := 0; i < maxDBAttempts; i++ {
-		repo, err = repository.New(cfg.PostgresDSN)
+		repo, err = repository.New(cfg.PostgresDSN, repository.Timeouts{
+			Read:   cfg.DBReadTimeout,
+			Write:  cfg.DBWriteTimeout,
+			Stream: cfg.DBStreamTimeout,
+		})
 		if err == nil {
 			break
 		}
@@ -72,175 +84,183 @@ func main() {
 		log.Fatal("Failed to apply migrations:", err)
 	}
 
-	cache := cache.New(cfg.CacheMaxSize, time.Duration(cfg.CacheTTLMinutes)*time.Minute)
-	defer cache.Stop()
-
-	orderService := service.NewOrderService(repo, cache)
-
-	if err := orderService.RestoreCacheFromDB(); err != nil {
-		mongoLogger.Log("ERROR", "server", "Failed to restore cache: "+err.Error())
-		log.Fatal("Failed to restore cache:", err)
+	evictionPolicy, err := cache.PolicyByName(cfg.CacheEviction)
+	if err != nil {
+		mongoLogger.Log("ERROR", "server", "Invalid cache configuration: "+err.Error())
+		log.Fatal("Invalid cache configuration:", err)
 	}
 
-	http.HandleFunc("/order/", func(w http.ResponseWriter, r *http.Request) {
-		uid := r.URL.Path[len("/order/"):]
-		if uid == "" {
-			http.Error(w, "Order ID required", http.StatusBadRequest)
-			return
-		}
-
-		order, err := orderService.GetOrderByID(uid)
-		if err != nil {
-			mongoLogger.Log("ERROR", "server", "Error getting order: "+err.Error())
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		if order == nil {
-			http.Error(w, "Order not found", http.StatusNotFound)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(order)
-	})
+	sizeEstimator, err := cache.NewSizeEstimator(cfg.CacheSizeEstimator)
+	if err != nil {
+		mongoLogger.Log("ERROR", "server", "Invalid cache configuration: "+err.Error())
+		log.Fatal("Invalid cache configuration:", err)
+	}
 
-	fs := http.FileServer(http.Dir("./web"))
-	http.Handle("/", fs)
+	cache := cache.New(cfg.CacheMaxSize, time.Duration(cfg.CacheTTLMinutes)*time.Minute,
+		cache.WithShards(cfg.CacheShards),
+		cache.WithEvictionPolicy(evictionPolicy),
+		cache.WithSizeEstimator(sizeEstimator),
+		cache.WithNegativeCache(cfg.CacheNegativeTTL, cfg.CacheNegativeMax),
+	)
+	defer cache.Stop()
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		if err := repo.Ping(); err != nil {
-			http.Error(w, "Database not connected", http.StatusServiceUnavailable)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	consistencyRules, err := service.ParseConsistencyRules(cfg.ConsistencyRules)
+	if err != nil {
+		mongoLogger.Log("ERROR", "server", "Invalid validation configuration: "+err.Error())
+		log.Fatal("Invalid validation configuration:", err)
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
+	fieldRules, err := service.CompileFieldRules(cfg.ValidationRules)
+	if err != nil {
+		mongoLogger.Log("ERROR", "server", "Invalid validation configuration: "+err.Error())
+		log.Fatal("Invalid validation configuration:", err)
+	}
 
-	http.HandleFunc("/add_order", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			mongoLogger.Log("WARN", "server", "Invalid method for /add_order: "+r.Method)
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+	orderService := service.NewOrderService(repo, cache).
+		WithConsistencyRules(consistencyRules).
+		WithFieldRules(fieldRules)
 
-		if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
-			mongoLogger.Log("WARN", "server", "Invalid Content-Type for /add_order: "+r.Header.Get("Content-Type"))
-			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
-			return
-		}
+	probe := health.NewProbe(repo)
 
-		var order models.Order
-		if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-			mongoLogger.Log("ERROR", "server", "Error decoding JSON: "+err.Error())
-			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
-			return
-		}
+	cachePublisher := invalidation.NewPublisher(repo.DB())
 
-		if err := orderService.CreateOrder(&order); err != nil {
-			mongoLogger.Log("ERROR", "server", "Error creating order: "+err.Error())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-			if strings.Contains(err.Error(), "validation error") {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			} else if strings.Contains(err.Error(), "already exists") {
-				http.Error(w, err.Error(), http.StatusConflict)
-			} else {
-				http.Error(w, "Error creating order: "+err.Error(), http.StatusInternalServerError)
+	if cfg.ValidationReload > 0 {
+		go orderService.WatchFieldRules(ctx, cfg.ValidationFile, cfg.ValidationReload, func(err error) {
+			if err != nil {
+				mongoLogger.Log("ERROR", "server", "Failed to reload validation rules: "+err.Error())
+				log.Printf("Failed to reload validation rules: %v", err)
+				return
 			}
-			return
-		}
-
-		mongoLogger.Log("INFO", "server", "Order created successfully: "+order.OrderUID)
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":    "success",
-			"message":   "Order created successfully",
-			"order_uid": order.OrderUID,
+			mongoLogger.Log("INFO", "server", "Validation rules reloaded")
+			log.Println("Validation rules reloaded")
 		})
-	})
-
-	http.HandleFunc("/cache/stats", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+	}
 
-		stats := map[string]interface{}{
-			"size":        cache.CurrentSize(),
-			"items_count": cache.ItemsCount(),
-			"max_size":    cache.MaxSize(),
-			"hits":        cache.Hits(),
-			"misses":      cache.Misses(),
-			"hit_ratio":   cache.HitRatio(),
+	// Apply the order changes made by other processes, and by this one,
+	// to the cache.
+	invalidations := invalidation.NewListener(cfg.PostgresDSN, orderService, mongoLogger, "server").
+		WithBatching(cfg.InvalidationBatch, cfg.InvalidationWait)
+	invalidationsDone := make(chan struct{})
+	go func() {
+		defer close(invalidationsDone)
+		if err := invalidations.Run(ctx); err != nil {
+			mongoLogger.Log("ERROR", "server", "Cache invalidation stopped: "+err.Error())
+			log.Printf("Cache invalidation stopped: %v", err)
 		}
+	}()
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(stats)
-	})
-
-	http.HandleFunc("/cache/search", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	var snapshotPath string
+	if cfg.CacheSnapshotDir != "" {
+		if err := os.MkdirAll(cfg.CacheSnapshotDir, 0o755); err != nil {
+			mongoLogger.Log("ERROR", "server", "Failed to create cache snapshot directory: "+err.Error())
+			log.Fatal("Failed to create cache snapshot directory:", err)
 		}
-
-		orderUID := r.URL.Query().Get("order_uid")
-		if orderUID == "" {
-			http.Error(w, "order_uid parameter is required", http.StatusBadRequest)
-			return
+		snapshotPath = filepath.Join(cfg.CacheSnapshotDir, "server-cache.snapshot")
+	}
+	// Snapshots stop only once the HTTP server has drained, so that the
+	// last one has every change made by requests.
+	snapshotCtx, stopSnapshots := context.WithCancel(context.Background())
+	defer stopSnapshots()
+	snapshotsDone := make(chan struct{})
+
+	// Fill the cache in the background, from the snapshot if there is a
+	// usable one, otherwise from the database. The service is live
+	// meanwhile but reports ready only once the cache is filled.
+	go func() {
+		defer close(snapshotsDone)
+
+		loaded := false
+		if snapshotPath != "" {
+			err := orderService.LoadCacheSnapshot(ctx, snapshotPath)
+			switch {
+			case err == nil:
+				loaded = true
+			case !errors.Is(err, os.ErrNotExist):
+				mongoLogger.Log("WARN", "server", "Ignoring cache snapshot: "+err.Error())
+				log.Printf("Ignoring cache snapshot: %v", err)
+			}
 		}
-
-		order, exists := cache.Get(orderUID)
-		if !exists {
-			http.Error(w, "Order not found in cache", http.StatusNotFound)
-			return
+		if !loaded {
+			err := orderService.WarmUpCache(ctx, service.WarmUpOptions{
+				MaxOrders: cfg.CacheWarmUpMax,
+				HotSet:    cfg.CacheWarmUpHotSet,
+			})
+			if err != nil {
+				mongoLogger.Log("ERROR", "server", "Failed to warm up cache: "+err.Error())
+				log.Printf("Failed to warm up cache: %v", err)
+			}
 		}
+		probe.SetReady(true)
 
-		dbOrder, err := repo.GetOrderByID(orderUID)
-		if err != nil {
-			mongoLogger.Log("ERROR", "server", "Error getting order from DB: "+err.Error())
+		if snapshotPath != "" {
+			orderService.RunCacheSnapshots(snapshotCtx, snapshotPath, cfg.CacheSnapshotEvery, func(err error) {
+				mongoLogger.Log("ERROR", "server", "Failed to save cache snapshot: "+err.Error())
+				log.Printf("Failed to save cache snapshot: %v", err)
+			})
 		}
+	}()
 
-		response := map[string]interface{}{
-			"in_cache": true,
-			"in_db":    dbOrder != nil,
-			"order":    order,
-		}
+	mux := http.NewServeMux()
+	apiHandler := api.NewHandler(orderService, cache, repo, cachePublisher, mongoLogger)
+	apiHandler.Register(mux)
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-	})
-
-	http.HandleFunc("/cache/clear", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		if err := repo.Ping(r.Context()); err != nil {
+			http.Error(w, "Database not connected", http.StatusServiceUnavailable)
 			return
 		}
-
-		cache.Clear()
-		mongoLogger.Log("INFO", "server", "Cache cleared manually")
-
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Cache cleared successfully"))
+		w.Write([]byte("OK"))
 	})
+	mux.HandleFunc("GET /health/live", probe.LiveHandler())
+	mux.HandleFunc("GET /health/ready", probe.ReadyHandler())
+
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	// The pprof handlers are registered on the default mux, which is
+	// served on its own port only.
 	go func() {
 		log.Println("pprof server started on :6060")
 		log.Println(http.ListenAndServe(":6060", nil))
 	}()
 
-	log.Printf("HTTP server started on :%s", cfg.HTTPServerPort)
-	mongoLogger.Log("INFO", "server", "HTTP server started on :"+cfg.HTTPServerPort)
 	srv := &http.Server{
 		Addr:         ":" + cfg.HTTPServerPort,
+		Handler:      apiHandler.Wrap(mux, http.FileServer(http.Dir("./web"))),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		mongoLogger.Log("ERROR", "server", "Failed to start HTTP server: "+err.Error())
+		log.Fatal("Failed to start HTTP server:", err)
+	}
 	log.Printf("HTTP server started on :%s", cfg.HTTPServerPort)
 	mongoLogger.Log("INFO", "server", "HTTP server started on :"+cfg.HTTPServerPort)
-	log.Fatal(srv.ListenAndServe())
+
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down server...")
+	}()
+
+	err = server.Serve(ctx, srv, ln, probe, server.ShutdownOptions{
+		Delay:   cfg.HTTPShutdownDelay,
+		Timeout: cfg.HTTPDrainTimeout,
+	})
+	if err != nil {
+		mongoLogger.Log("ERROR", "server", "HTTP server stopped: "+err.Error())
+		log.Printf("HTTP server stopped: %v", err)
+	}
+
+	// Close the remaining components in order. The deferred calls then stop
+	// the cache and close the database and the logger.
+	stop()
+	<-invalidationsDone
+	stopSnapshots()
+	<-snapshotsDone
+	log.Println("Server stopped")
 }
@@ -0,0 +1,9 @@
+package configs
+
+import _ "embed"
+
+// Validation holds the default validation rules, used until rules are
+// loaded from a file.
+//
+//go:embed validation.json
+var Validation []byte
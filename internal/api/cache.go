@@ -0,0 +1,78 @@
+package api
+
+import (
+	models "L0/internal/model"
+	"L0/internal/service"
+	"net/http"
+)
+
+type CacheStatsResponse struct {
+	Size       int64   `json:"size"`
+	ItemsCount int     `json:"items_count"`
+	MaxSize    int64   `json:"max_size"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	HitRatio   float64 `json:"hit_ratio"`
+}
+
+type CacheSearchResponse struct {
+	InCache bool          `json:"in_cache"`
+	InDB    bool          `json:"in_db"`
+	Order   *models.Order `json:"order"`
+}
+
+func (h *Handler) cacheStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, CacheStatsResponse{
+		Size:       h.cache.CurrentSize(),
+		ItemsCount: h.cache.ItemsCount(),
+		MaxSize:    h.cache.MaxSize(),
+		Hits:       h.cache.Hits(),
+		Misses:     h.cache.Misses(),
+		HitRatio:   h.cache.HitRatio(),
+	})
+}
+
+// searchCache reports whether an order is cached and stored. The uid is a
+// path parameter, or the order_uid query parameter on the old path.
+func (h *Handler) searchCache(w http.ResponseWriter, r *http.Request) {
+	orderUID := r.PathValue("uid")
+	if orderUID == "" {
+		orderUID = r.URL.Query().Get("order_uid")
+	}
+	if orderUID == "" {
+		h.writeError(w, r, &service.ValidationError{Field: "order_uid", Code: service.CodeRequired, Message: "is required"})
+		return
+	}
+
+	order, exists := h.cache.Get(orderUID)
+	if !exists {
+		writeErrorMessage(w, http.StatusNotFound, "Order not found in cache")
+		return
+	}
+
+	dbOrder, err := h.store.GetOrderByID(r.Context(), orderUID)
+	if err != nil {
+		h.logger.Log("ERROR", "server", "Error getting order from DB: "+err.Error())
+	}
+
+	writeJSON(w, http.StatusOK, CacheSearchResponse{
+		InCache: true,
+		InDB:    dbOrder != nil,
+		Order:   order,
+	})
+}
+
+func (h *Handler) clearCache(w http.ResponseWriter, r *http.Request) {
+	h.orders.ClearCache()
+	h.logger.Log("INFO", "server", "Cache cleared manually")
+
+	// Other processes clear their caches when they receive the event.
+	if err := h.publisher.Clear(r.Context()); err != nil {
+		h.logger.Log("ERROR", "server", "Failed to clear other caches: "+err.Error())
+		writeErrorMessage(w, http.StatusInternalServerError, "Cache cleared locally only: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Cache cleared successfully"))
+}
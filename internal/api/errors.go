@@ -0,0 +1,68 @@
+package api
+
+import (
+	"L0/internal/service"
+	"L0/pkg/errormsg"
+	"errors"
+	"net/http"
+)
+
+var (
+	// errNoRoute is returned for a path no route matches.
+	errNoRoute = errors.New("not found")
+	// errMethodNotAllowed is returned for a path whose routes take other
+	// methods.
+	errMethodNotAllowed = errors.New("method not allowed")
+)
+
+// errorStatus maps an error returned by the service to an HTTP status.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNoRoute):
+		return http.StatusNotFound
+	case errors.Is(err, errMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+	case service.IsValidation(err):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrOrderExists), errors.Is(err, service.ErrStaleVersion):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError responds with the status err maps to. Validation errors list
+// every invalid field; the details of internal errors are logged, not sent
+// to the client.
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		h.logger.Log("ERROR", "server", r.Method+" "+r.URL.Path+": "+err.Error())
+		writeErrorMessage(w, status, "Internal server error")
+		return
+	}
+
+	h.logger.Log("WARN", "server", r.Method+" "+r.URL.Path+": "+err.Error())
+
+	resp := errormsg.ErrorResponse{
+		Error:   true,
+		Message: err.Error(),
+	}
+	for _, verr := range service.Violations(err) {
+		resp.Errors = append(resp.Errors, errormsg.FieldError{
+			Field:   verr.Field,
+			Code:    verr.Code,
+			Message: verr.Message,
+		})
+	}
+	writeJSON(w, status, resp)
+}
+
+func writeErrorMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errormsg.ErrorResponse{
+		Error:   true,
+		Message: message,
+	})
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"L0/internal/cache"
+	models "L0/internal/model"
+	"L0/internal/repository"
+	"L0/internal/service"
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// Prefix is the path prefix of the versioned API.
+const Prefix = "/api/v1"
+
+// OrderService is the part of *service.OrderService the handlers use.
+type OrderService interface {
+	CreateOrder(ctx context.Context, order *models.Order) error
+	UpdateOrder(ctx context.Context, order *models.Order) error
+	GetOrderByID(ctx context.Context, orderUID string) (*models.Order, error)
+	ListOrders(ctx context.Context, filter repository.OrderFilter, cursor string, limit int) (*service.OrderPage, error)
+	GetOrdersByTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error)
+	GetOrdersByItemTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error)
+	GetOrdersByChrtID(ctx context.Context, chrtID int) ([]*models.Order, error)
+	GetOrdersByNmID(ctx context.Context, nmID int) ([]*models.Order, error)
+	ClearCache()
+}
+
+// OrderStore reads orders from the database, bypassing the cache.
+type OrderStore interface {
+	GetOrderByID(ctx context.Context, orderUID string) (*models.Order, error)
+}
+
+// CachePublisher tells other processes to clear their caches.
+type CachePublisher interface {
+	Clear(ctx context.Context) error
+}
+
+type Logger interface {
+	Log(level, service, message string)
+}
+
+type Handler struct {
+	orders    OrderService
+	cache     *cache.Cache
+	store     OrderStore
+	publisher CachePublisher
+	logger    Logger
+}
+
+func NewHandler(orders OrderService, cache *cache.Cache, store OrderStore, publisher CachePublisher, logger Logger) *Handler {
+	return &Handler{
+		orders:    orders,
+		cache:     cache,
+		store:     store,
+		publisher: publisher,
+		logger:    logger,
+	}
+}
+
+// Register adds the API routes to mux, under Prefix and under the paths
+// the server used before the API was versioned. A {uid} segment matches
+// only a non-empty uid, so the paths ending in a slash are registered too,
+// to report the missing uid rather than fall through to the static files.
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST "+Prefix+"/orders", h.createOrder)
+	mux.HandleFunc("GET "+Prefix+"/orders", h.listOrders)
+	mux.HandleFunc("GET "+Prefix+"/orders/lookup", h.lookupOrders)
+	mux.HandleFunc("GET "+Prefix+"/orders/{uid}", h.getOrder)
+	mux.HandleFunc("GET "+Prefix+"/orders/{$}", h.getOrder)
+	mux.HandleFunc("PUT "+Prefix+"/orders/{uid}", h.updateOrder)
+	mux.HandleFunc("GET "+Prefix+"/cache/stats", h.cacheStats)
+	mux.HandleFunc("GET "+Prefix+"/cache/orders/{uid}", h.searchCache)
+	mux.HandleFunc("GET "+Prefix+"/cache/orders/{$}", h.searchCache)
+	mux.HandleFunc("POST "+Prefix+"/cache/clear", h.clearCache)
+
+	// Compatibility aliases
+	mux.HandleFunc("POST /add_order", h.createOrder)
+	mux.HandleFunc("GET /orders", h.listOrders)
+	mux.HandleFunc("GET /orders/lookup", h.lookupOrders)
+	mux.HandleFunc("GET /order/{uid}", h.getOrder)
+	mux.HandleFunc("GET /order/{$}", h.getOrder)
+	mux.HandleFunc("PUT /order/{uid}", h.updateOrder)
+	mux.HandleFunc("GET /cache/stats", h.cacheStats)
+	mux.HandleFunc("GET /cache/search", h.searchCache)
+	mux.HandleFunc("POST /cache/clear", h.clearCache)
+}
+
+// Wrap returns a handler serving mux. GET requests no route matches go to
+// static, if it is not nil, unless they are under Prefix. Other requests no
+// route matches get a JSON error like the API routes, 405 Method Not Allowed
+// if the path has routes for other methods and 404 Not Found otherwise.
+func (h *Handler) Wrap(mux *http.ServeMux, static http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// The handler of the mux sets the Allow header of a 405.
+		rec := &statusRecorder{header: w.Header()}
+		handler.ServeHTTP(rec, r)
+		if rec.status == http.StatusMethodNotAllowed {
+			h.writeError(w, r, errMethodNotAllowed)
+			return
+		}
+
+		isGet := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if static != nil && isGet && !strings.HasPrefix(r.URL.Path, Prefix+"/") {
+			static.ServeHTTP(w, r)
+			return
+		}
+		h.writeError(w, r, errNoRoute)
+	})
+}
+
+// statusRecorder keeps the status written to it and discards the body.
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (r *statusRecorder) Header() http.Header         { return r.header }
+func (r *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (r *statusRecorder) WriteHeader(status int)      { r.status = status }
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"L0/internal/cache"
+	models "L0/internal/model"
+	"L0/internal/repository"
+	"L0/internal/service"
+	"L0/pkg/errormsg"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockOrderService struct {
+	mock.Mock
+}
+
+func (m *MockOrderService) CreateOrder(ctx context.Context, order *models.Order) error {
+	args := m.Called(order)
+	return args.Error(0)
+}
+
+func (m *MockOrderService) UpdateOrder(ctx context.Context, order *models.Order) error {
+	args := m.Called(order)
+	return args.Error(0)
+}
+
+func (m *MockOrderService) GetOrderByID(ctx context.Context, orderUID string) (*models.Order, error) {
+	args := m.Called(orderUID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Order), args.Error(1)
+}
+
+func (m *MockOrderService) ListOrders(ctx context.Context, filter repository.OrderFilter, cursor string, limit int) (*service.OrderPage, error) {
+	args := m.Called(filter, cursor, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.OrderPage), args.Error(1)
+}
+
+func (m *MockOrderService) GetOrdersByTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error) {
+	args := m.Called(trackNumber)
+	return args.Get(0).([]*models.Order), args.Error(1)
+}
+
+func (m *MockOrderService) GetOrdersByItemTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error) {
+	args := m.Called(trackNumber)
+	return args.Get(0).([]*models.Order), args.Error(1)
+}
+
+func (m *MockOrderService) GetOrdersByChrtID(ctx context.Context, chrtID int) ([]*models.Order, error) {
+	args := m.Called(chrtID)
+	return args.Get(0).([]*models.Order), args.Error(1)
+}
+
+func (m *MockOrderService) GetOrdersByNmID(ctx context.Context, nmID int) ([]*models.Order, error) {
+	args := m.Called(nmID)
+	return args.Get(0).([]*models.Order), args.Error(1)
+}
+
+func (m *MockOrderService) ClearCache() {
+	m.Called()
+}
+
+type fakeStore map[string]*models.Order
+
+func (s fakeStore) GetOrderByID(ctx context.Context, orderUID string) (*models.Order, error) {
+	return s[orderUID], nil
+}
+
+type fakePublisher struct {
+	err     error
+	cleared int
+}
+
+func (p *fakePublisher) Clear(ctx context.Context) error {
+	p.cleared++
+	return p.err
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(level, service, message string) {}
+
+type testServer struct {
+	orders    *MockOrderService
+	cache     *cache.Cache
+	store     fakeStore
+	publisher *fakePublisher
+	handler   http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{
+		orders:    new(MockOrderService),
+		cache:     cache.New(1<<20, time.Hour),
+		store:     fakeStore{},
+		publisher: &fakePublisher{},
+	}
+	t.Cleanup(s.cache.Stop)
+
+	mux := http.NewServeMux()
+	handler := NewHandler(s.orders, s.cache, s.store, s.publisher, nopLogger{})
+	handler.Register(mux)
+	static := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("static " + r.URL.Path))
+	})
+	s.handler = handler.Wrap(mux, static)
+	return s
+}
+
+func (s *testServer) do(method, path, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) errormsg.ErrorResponse {
+	t.Helper()
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var resp errormsg.ErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestGetOrder(t *testing.T) {
+	for _, path := range []string{Prefix + "/orders/", "/order/"} {
+		t.Run(path, func(t *testing.T) {
+			s := newTestServer(t)
+			s.orders.On("GetOrderByID", "found").Return(&models.Order{OrderUID: "found"}, nil)
+			s.orders.On("GetOrderByID", "missing").Return(nil, nil)
+			s.orders.On("GetOrderByID", "broken").Return(nil, errors.New("connection refused"))
+
+			rec := s.do(http.MethodGet, path+"found", "", "")
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var order models.Order
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &order))
+			assert.Equal(t, "found", order.OrderUID)
+
+			rec = s.do(http.MethodGet, path+"missing", "", "")
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Equal(t, "order missing: order not found", decodeError(t, rec).Message)
+
+			// Internal errors are not exposed.
+			rec = s.do(http.MethodGet, path+"broken", "", "")
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+			assert.Equal(t, "Internal server error", decodeError(t, rec).Message)
+
+			// An empty uid is not left to the static files.
+			rec = s.do(http.MethodGet, path, "", "")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, []errormsg.FieldError{
+				{Field: "order_uid", Code: "required", Message: "is required"},
+			}, decodeError(t, rec).Errors)
+		})
+	}
+}
+
+func TestCreateOrder(t *testing.T) {
+	for _, path := range []string{Prefix + "/orders", "/add_order"} {
+		t.Run(path, func(t *testing.T) {
+			s := newTestServer(t)
+			isUID := func(uid string) any {
+				return mock.MatchedBy(func(o *models.Order) bool { return o.OrderUID == uid })
+			}
+			s.orders.On("CreateOrder", isUID("new")).Return(nil)
+			s.orders.On("CreateOrder", isUID("dup")).Return(fmt.Errorf("order dup: %w", service.ErrOrderExists))
+			s.orders.On("CreateOrder", isUID("bad")).Return(service.ValidationErrors{
+				{Field: "delivery.phone", Code: service.CodeInvalidFormat, Message: "format is invalid"},
+				{Field: "items[3].price", Code: service.CodeNotPositive, Message: "must be positive"},
+			})
+
+			rec := s.do(http.MethodPost, path, "application/json", `{"order_uid":"new"}`)
+			require.Equal(t, http.StatusCreated, rec.Code)
+			var resp OrderStatusResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, "new", resp.OrderUID)
+
+			assert.Equal(t, http.StatusConflict, s.do(http.MethodPost, path, "application/json", `{"order_uid":"dup"}`).Code)
+			rec = s.do(http.MethodPost, path, "application/json", `{"order_uid":"bad"}`)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, errormsg.ErrorResponse{
+				Error:   true,
+				Message: "validation error: delivery.phone: format is invalid; items[3].price: must be positive",
+				Errors: []errormsg.FieldError{
+					{Field: "delivery.phone", Code: "invalid_format", Message: "format is invalid"},
+					{Field: "items[3].price", Code: "not_positive", Message: "must be positive"},
+				},
+			}, decodeError(t, rec))
+			assert.Equal(t, http.StatusBadRequest, s.do(http.MethodPost, path, "application/json", `{`).Code)
+			assert.Equal(t, http.StatusUnsupportedMediaType, s.do(http.MethodPost, path, "text/plain", `{}`).Code)
+		})
+	}
+}
+
+func TestUpdateOrder(t *testing.T) {
+	s := newTestServer(t)
+	isUID := func(uid string) any {
+		return mock.MatchedBy(func(o *models.Order) bool { return o.OrderUID == uid })
+	}
+	s.orders.On("UpdateOrder", isUID("a")).Return(nil)
+	s.orders.On("UpdateOrder", isUID("stale")).Return(fmt.Errorf("order stale: %w", service.ErrStaleVersion))
+	s.orders.On("UpdateOrder", isUID("missing")).Return(fmt.Errorf("order missing: %w", service.ErrNotFound))
+
+	// The uid is taken from the URL when the body has none.
+	rec := s.do(http.MethodPut, Prefix+"/orders/a", "application/json", `{"version":2}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp OrderStatusResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "a", resp.OrderUID)
+	assert.Equal(t, 2, resp.Version)
+
+	assert.Equal(t, http.StatusOK, s.do(http.MethodPut, "/order/a", "application/json", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, s.do(http.MethodPut, Prefix+"/orders/a", "application/json", `{"order_uid":"b"}`).Code)
+	assert.Equal(t, http.StatusConflict, s.do(http.MethodPut, Prefix+"/orders/stale", "application/json", `{}`).Code)
+	assert.Equal(t, http.StatusNotFound, s.do(http.MethodPut, Prefix+"/orders/missing", "application/json", `{}`).Code)
+}
+
+func TestListOrders(t *testing.T) {
+	s := newTestServer(t)
+	s.orders.On("ListOrders", repository.OrderFilter{Currency: "USD"}, "abc", 10).
+		Return(&service.OrderPage{Orders: []*models.Order{{OrderUID: "a"}}, NextCursor: "def"}, nil)
+
+	for _, path := range []string{Prefix + "/orders", "/orders"} {
+		rec := s.do(http.MethodGet, path+"?currency=USD&cursor=abc&limit=10", "", "")
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		var page service.OrderPage
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		assert.Equal(t, "def", page.NextCursor)
+		require.Len(t, page.Orders, 1)
+	}
+
+	rec := s.do(http.MethodGet, Prefix+"/orders?limit=0", "", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, []errormsg.FieldError{{Field: "limit", Code: "invalid_format", Message: "must be a positive integer"}}, decodeError(t, rec).Errors)
+	assert.Equal(t, http.StatusBadRequest, s.do(http.MethodGet, Prefix+"/orders?created_from=yesterday", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, s.do(http.MethodGet, Prefix+"/orders?updated_since=yesterday", "", "").Code)
+
+	// Orders changed since a time, with the timestamps the database set.
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	s.orders.On("ListOrders", repository.OrderFilter{UpdatedSince: since}, "", 0).
+		Return(&service.OrderPage{Orders: []*models.Order{{OrderUID: "b", CreatedAt: since, UpdatedAt: since.Add(time.Hour)}}}, nil)
+	rec = s.do(http.MethodGet, Prefix+"/orders?updated_since=2026-10-01T00:00:00Z", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"created_at":"2026-10-01T00:00:00Z","updated_at":"2026-10-01T01:00:00Z"`)
+}
+
+func TestLookupOrders(t *testing.T) {
+	s := newTestServer(t)
+	s.orders.On("GetOrdersByChrtID", 42).Return([]*models.Order{{OrderUID: "a"}}, nil)
+
+	for _, path := range []string{Prefix + "/orders/lookup", "/orders/lookup"} {
+		rec := s.do(http.MethodGet, path+"?chrt_id=42", "", "")
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		var resp LookupResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.Orders, 1)
+	}
+
+	for _, query := range []string{"", "?chrt_id=1&nm_id=2"} {
+		rec := s.do(http.MethodGet, Prefix+"/orders/lookup"+query, "", "")
+		require.Equal(t, http.StatusBadRequest, rec.Code, query)
+		assert.Equal(t, []errormsg.FieldError{{
+			Field:   "track_number, item_track_number, chrt_id, nm_id",
+			Code:    service.CodeRequired,
+			Message: "exactly one is required",
+		}}, decodeError(t, rec).Errors, query)
+	}
+	assert.Equal(t, http.StatusBadRequest, s.do(http.MethodGet, Prefix+"/orders/lookup?nm_id=x", "", "").Code)
+}
+
+func TestCacheEndpoints(t *testing.T) {
+	s := newTestServer(t)
+	s.cache.Set(&models.Order{OrderUID: "a"})
+	s.store["a"] = &models.Order{OrderUID: "a"}
+	s.orders.On("ClearCache").Return()
+
+	rec := s.do(http.MethodGet, Prefix+"/cache/stats", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats CacheStatsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, 1, stats.ItemsCount)
+
+	for _, path := range []string{Prefix + "/cache/orders/a", "/cache/search?order_uid=a"} {
+		rec := s.do(http.MethodGet, path, "", "")
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		var resp CacheSearchResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.True(t, resp.InCache)
+		assert.True(t, resp.InDB)
+	}
+	assert.Equal(t, http.StatusNotFound, s.do(http.MethodGet, Prefix+"/cache/orders/b", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, s.do(http.MethodGet, "/cache/search", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, s.do(http.MethodGet, Prefix+"/cache/orders/", "", "").Code)
+
+	assert.Equal(t, http.StatusOK, s.do(http.MethodPost, Prefix+"/cache/clear", "", "").Code)
+	s.publisher.err = errors.New("connection refused")
+	assert.Equal(t, http.StatusInternalServerError, s.do(http.MethodPost, "/cache/clear", "", "").Code)
+	assert.Equal(t, 2, s.publisher.cleared)
+	s.orders.AssertNumberOfCalls(t, "ClearCache", 2)
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodDelete, Prefix + "/orders/a"},
+		{http.MethodPost, Prefix + "/orders/a"},
+		{http.MethodGet, Prefix + "/cache/clear"},
+		{http.MethodGet, "/add_order"},
+		{http.MethodPost, "/cache/stats"},
+	} {
+		rec := s.do(tc.method, tc.path, "", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code, tc.method+" "+tc.path)
+		assert.Equal(t, "method not allowed", decodeError(t, rec).Message)
+	}
+
+	rec := s.do(http.MethodDelete, Prefix+"/orders/a", "", "")
+	assert.Equal(t, "GET, HEAD, PUT", rec.Header().Get("Allow"))
+}
+
+func TestNotFound(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, Prefix + "/unknown"},
+		{http.MethodGet, Prefix + "/orders/a/items"},
+		{http.MethodPost, "/unknown"},
+		{http.MethodDelete, "/"},
+	} {
+		rec := s.do(tc.method, tc.path, "", "")
+		assert.Equal(t, http.StatusNotFound, rec.Code, tc.method+" "+tc.path)
+		assert.Equal(t, "not found", decodeError(t, rec).Message)
+	}
+
+	// Other GET requests are left to the static files.
+	for _, path := range []string{"/", "/script.js"} {
+		rec := s.do(http.MethodGet, path, "", "")
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Equal(t, "static "+path, rec.Body.String())
+	}
+}
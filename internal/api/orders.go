@@ -0,0 +1,219 @@
+package api
+
+import (
+	models "L0/internal/model"
+	"L0/internal/repository"
+	"L0/internal/service"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// OrderStatusResponse acknowledges a stored order.
+type OrderStatusResponse struct {
+	Status   string          `json:"status"`
+	Message  string          `json:"message"`
+	OrderUID string          `json:"order_uid"`
+	Version  int             `json:"version,omitempty"`
+	Warnings models.Warnings `json:"warnings,omitempty"`
+}
+
+// LookupResponse holds the orders found by a lookup.
+type LookupResponse struct {
+	Orders []*models.Order `json:"orders"`
+}
+
+func (h *Handler) getOrder(w http.ResponseWriter, r *http.Request) {
+	uid := r.PathValue("uid")
+	if uid == "" {
+		h.writeError(w, r, &service.ValidationError{Field: "order_uid", Code: service.CodeRequired, Message: "is required"})
+		return
+	}
+
+	order, err := h.orders.GetOrderByID(r.Context(), uid)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if order == nil {
+		h.writeError(w, r, fmt.Errorf("order %s: %w", uid, service.ErrNotFound))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, order)
+}
+
+func (h *Handler) createOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := h.decodeOrder(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.orders.CreateOrder(r.Context(), order); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.logger.Log("INFO", "server", "Order created successfully: "+order.OrderUID)
+
+	writeJSON(w, http.StatusCreated, OrderStatusResponse{
+		Status:   "success",
+		Message:  "Order created successfully",
+		OrderUID: order.OrderUID,
+		Warnings: order.Warnings,
+	})
+}
+
+func (h *Handler) updateOrder(w http.ResponseWriter, r *http.Request) {
+	uid := r.PathValue("uid")
+
+	order, ok := h.decodeOrder(w, r)
+	if !ok {
+		return
+	}
+
+	if order.OrderUID == "" {
+		order.OrderUID = uid
+	}
+	if order.OrderUID != uid {
+		h.writeError(w, r, &service.ValidationError{Field: "order_uid", Code: service.CodeMismatch, Message: "does not match the URL"})
+		return
+	}
+
+	if err := h.orders.UpdateOrder(r.Context(), order); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.logger.Log("INFO", "server", "Order updated successfully: "+order.OrderUID)
+
+	writeJSON(w, http.StatusOK, OrderStatusResponse{
+		Status:   "success",
+		Message:  "Order updated successfully",
+		OrderUID: order.OrderUID,
+		Version:  order.Version,
+		Warnings: order.Warnings,
+	})
+}
+
+// decodeOrder reads a JSON order from the request body. It writes the
+// error response itself and reports whether decoding succeeded.
+func (h *Handler) decodeOrder(w http.ResponseWriter, r *http.Request) (*models.Order, bool) {
+	if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		h.logger.Log("WARN", "server", "Invalid Content-Type for "+r.URL.Path+": "+r.Header.Get("Content-Type"))
+		writeErrorMessage(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return nil, false
+	}
+
+	var order models.Order
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		h.logger.Log("WARN", "server", "Error decoding JSON: "+err.Error())
+		writeErrorMessage(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return nil, false
+	}
+
+	return &order, true
+}
+
+func (h *Handler) listOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := repository.OrderFilter{
+		CustomerID:      query.Get("customer_id"),
+		TrackNumber:     query.Get("track_number"),
+		DeliveryService: query.Get("delivery_service"),
+		Currency:        query.Get("currency"),
+		Provider:        query.Get("provider"),
+		Brand:           query.Get("brand"),
+	}
+
+	var err error
+	if v := query.Get("created_from"); v != "" {
+		if filter.CreatedFrom, err = time.Parse(time.RFC3339, v); err != nil {
+			h.writeError(w, r, &service.ValidationError{Field: "created_from", Code: service.CodeInvalidFormat, Message: "must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+	if v := query.Get("created_to"); v != "" {
+		if filter.CreatedTo, err = time.Parse(time.RFC3339, v); err != nil {
+			h.writeError(w, r, &service.ValidationError{Field: "created_to", Code: service.CodeInvalidFormat, Message: "must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+	if v := query.Get("updated_since"); v != "" {
+		if filter.UpdatedSince, err = time.Parse(time.RFC3339, v); err != nil {
+			h.writeError(w, r, &service.ValidationError{Field: "updated_since", Code: service.CodeInvalidFormat, Message: "must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			h.writeError(w, r, &service.ValidationError{Field: "limit", Code: service.CodeInvalidFormat, Message: "must be a positive integer"})
+			return
+		}
+	}
+
+	page, err := h.orders.ListOrders(r.Context(), filter, query.Get("cursor"), limit)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) lookupOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var lookup func(ctx context.Context) ([]*models.Order, error)
+	lookups := 0
+
+	if v := query.Get("track_number"); v != "" {
+		lookups++
+		lookup = func(ctx context.Context) ([]*models.Order, error) { return h.orders.GetOrdersByTrackNumber(ctx, v) }
+	}
+	if v := query.Get("item_track_number"); v != "" {
+		lookups++
+		lookup = func(ctx context.Context) ([]*models.Order, error) { return h.orders.GetOrdersByItemTrackNumber(ctx, v) }
+	}
+	for _, param := range []string{"chrt_id", "nm_id"} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			h.writeError(w, r, &service.ValidationError{Field: param, Code: service.CodeInvalidFormat, Message: "must be an integer"})
+			return
+		}
+		lookups++
+		if param == "chrt_id" {
+			lookup = func(ctx context.Context) ([]*models.Order, error) { return h.orders.GetOrdersByChrtID(ctx, id) }
+		} else {
+			lookup = func(ctx context.Context) ([]*models.Order, error) { return h.orders.GetOrdersByNmID(ctx, id) }
+		}
+	}
+
+	if lookups != 1 {
+		h.writeError(w, r, &service.ValidationError{
+			Field:   "track_number, item_track_number, chrt_id, nm_id",
+			Code:    service.CodeRequired,
+			Message: "exactly one is required",
+		})
+		return
+	}
+
+	orders, err := lookup(r.Context())
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, LookupResponse{Orders: orders})
+}
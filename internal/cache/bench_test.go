@@ -2,6 +2,7 @@ package cache
 
 import (
 	models "L0/internal/model"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -24,3 +25,61 @@ func BenchmarkCacheGet(b *testing.B) {
 		c.Get("uid")
 	}
 }
+
+// The parallel benchmarks compare a single segment, which locks the whole
+// cache like the unsharded implementation did, against the default shards.
+func benchmarkCacheGetParallel(b *testing.B, shards int) {
+	c := New(1e9, 10*time.Minute, WithShards(shards))
+	uids := make([]string, 1024)
+	for i := range uids {
+		uids[i] = "uid-" + strconv.Itoa(i)
+		c.Set(&models.Order{OrderUID: uids[i], TrackNumber: "T"})
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(uids[i%len(uids)])
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheGetParallel_SingleLock(b *testing.B) {
+	benchmarkCacheGetParallel(b, 1)
+}
+
+func BenchmarkCacheGetParallel_Sharded(b *testing.B) {
+	benchmarkCacheGetParallel(b, DefaultShards)
+}
+
+func benchmarkCacheMixedParallel(b *testing.B, shards int) {
+	c := New(1e9, 10*time.Minute, WithShards(shards))
+	orders := make([]*models.Order, 1024)
+	for i := range orders {
+		orders[i] = &models.Order{OrderUID: "uid-" + strconv.Itoa(i), TrackNumber: "T"}
+		c.Set(orders[i])
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			order := orders[i%len(orders)]
+			// One write in ten operations
+			if i%10 == 0 {
+				c.Set(order)
+			} else {
+				c.Get(order.OrderUID)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheMixedParallel_SingleLock(b *testing.B) {
+	benchmarkCacheMixedParallel(b, 1)
+}
+
+func BenchmarkCacheMixedParallel_Sharded(b *testing.B) {
+	benchmarkCacheMixedParallel(b, DefaultShards)
+}
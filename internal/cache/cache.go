@@ -4,9 +4,14 @@ import (
 	"L0/internal/metrics"
 	"L0/internal/model"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultShards is the number of independently locked segments a cache is
+// split into unless WithShards says otherwise.
+const DefaultShards = 16
+
 type CacheItem struct {
 	Order     *models.Order
 	ExpiresAt time.Time
@@ -14,22 +19,93 @@ type CacheItem struct {
 }
 
 type Cache struct {
-	sync.RWMutex
+	shards    []*shard
+	index     secondaryIndex
+	maxSize   int64
+	ttl       time.Duration
+	newPolicy func() EvictionPolicy
+	sizeOf    SizeEstimator
+	stopChan  chan bool
+
+	negativeTTL time.Duration
+	negativeMax int
+
+	size          atomic.Int64
+	count         atomic.Int64
+	negativeCount atomic.Int64
+	hits          atomic.Int64
+	misses        atomic.Int64
+}
+
+// shard is a segment of the cache with its own lock and its own eviction
+// policy. Orders are assigned to shards by a hash of their uid. All shards
+// share the size budget of the cache.
+type shard struct {
+	sync.Mutex
 	items       map[string]*CacheItem
-	maxSize     int64
 	currentSize int64
-	ttl         time.Duration
-	stopChan    chan bool
-	hits        int64
-	misses      int64
+	policy      EvictionPolicy
+	admitter    Admitter // the policy, if it filters new keys
+	negative    negativeCache
+}
+
+// Option configures a Cache.
+type Option func(*Cache)
+
+// WithShards sets the number of segments. They share one size budget, so
+// an order of any size up to the budget can be cached.
+func WithShards(n int) Option {
+	return func(c *Cache) {
+		if n > 0 {
+			c.shards = make([]*shard, n)
+		}
+	}
+}
+
+// WithSizeEstimator sets how orders are measured against the cache budget.
+// The default is StructSize.
+func WithSizeEstimator(sizeOf SizeEstimator) Option {
+	return func(c *Cache) {
+		c.sizeOf = sizeOf
+	}
 }
 
-func New(maxSize int64, ttl time.Duration) *Cache {
+// WithEvictionPolicy sets the policy choosing what to evict when the cache
+// is full. Every shard gets its own policy from newPolicy. The default is
+// LRU.
+func WithEvictionPolicy(newPolicy func() EvictionPolicy) Option {
+	return func(c *Cache) {
+		c.newPolicy = newPolicy
+	}
+}
+
+func New(maxSize int64, ttl time.Duration, opts ...Option) *Cache {
 	cache := &Cache{
-		items:    make(map[string]*CacheItem),
-		maxSize:  maxSize,
-		ttl:      ttl,
-		stopChan: make(chan bool),
+		shards:    make([]*shard, DefaultShards),
+		index:     newSecondaryIndex(),
+		maxSize:   maxSize,
+		ttl:       ttl,
+		newPolicy: func() EvictionPolicy { return NewLRU() },
+		sizeOf:    StructSize,
+		stopChan:  make(chan bool),
+	}
+	for _, opt := range opts {
+		opt(cache)
+	}
+
+	negativeMax := 0
+	if cache.negativeMax > 0 {
+		negativeMax = max(cache.negativeMax/len(cache.shards), 1)
+	}
+	for i := range cache.shards {
+		policy := cache.newPolicy()
+		admitter, _ := policy.(Admitter)
+		cache.shards[i] = &shard{
+			items:    make(map[string]*CacheItem),
+			policy:   policy,
+			admitter: admitter,
+			negative: newNegativeCache(cache.negativeTTL, negativeMax),
+		}
 	}
 
 	go cache.cleanup()
@@ -37,81 +113,318 @@ func New(maxSize int64, ttl time.Duration) *Cache {
 	return cache
 }
 
+// shardFor picks the shard of a key using FNV-1a.
+func (c *Cache) shardFor(key string) *shard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
 func (c *Cache) Set(order *models.Order) {
-	c.Lock()
-	defer c.Unlock()
+	s := c.shardFor(order.OrderUID)
+	s.Lock()
+	c.set(s, order)
+	s.Unlock()
+	c.shrink(order.OrderUID)
+}
 
-	if existing, exists := c.items[order.OrderUID]; exists {
-		c.currentSize -= existing.Size
-		delete(c.items, order.OrderUID)
+// set stores order, evicting other orders of its shard until it fits. If
+// the shard runs out of orders first, the cache is left over its budget
+// for shrink to make up. An order larger than the whole cache is not
+// stored, and neither is a new order the admission filter of the policy
+// refuses.
+func (c *Cache) set(s *shard, order *models.Order) {
+	_, exists := s.items[order.OrderUID]
+	if exists {
+		c.remove(s, order.OrderUID, false)
 	}
+	if s.admitter != nil {
+		s.admitter.Record(order.OrderUID)
+	}
+
+	size := c.sizeOf(order)
+	if size > c.maxSize {
+		c.forgetMissing(s, order.OrderUID)
+		c.index.invalidate(order)
+		c.updateMetrics()
+		return
+	}
+
+	for c.size.Load()+size > c.maxSize {
+		if !exists && !c.admit(s, order.OrderUID) {
+			// The order exists although it is not stored.
+			c.forgetMissing(s, order.OrderUID)
+			c.index.invalidate(order)
+			c.updateMetrics()
+			return
+		}
+		if !c.evict(s) {
+			break
+		}
+	}
+
+	c.store(s, order, size, time.Now().Add(c.ttl))
+	c.updateMetrics()
+}
 
-	size := int64(len(order.OrderUID)) + int64(len(order.TrackNumber)) + 1024
+// shrink evicts orders of any shard, one per shard in turn, until the
+// cache fits its budget again. The order with keep, just stored, is not
+// evicted.
+func (c *Cache) shrink(keep string) {
+	for evicted := true; evicted && c.size.Load() > c.maxSize; {
+		evicted = false
+		for _, s := range c.shards {
+			if c.size.Load() <= c.maxSize {
+				break
+			}
+			s.Lock()
+			if victim, ok := s.policy.Victim(); ok && victim != keep && c.evict(s) {
+				evicted = true
+			}
+			s.Unlock()
+		}
+	}
+	c.updateMetrics()
+}
 
-	if c.currentSize+size > c.maxSize {
-		c.evictOldest()
+// admit reports whether the admission filter of s, if any, lets uid take
+// the place of the next victim.
+func (c *Cache) admit(s *shard, uid string) bool {
+	if s.admitter == nil {
+		return true
 	}
+	victim, ok := s.policy.Victim()
+	return !ok || s.admitter.Admit(uid, victim)
+}
 
-	c.items[order.OrderUID] = &CacheItem{
+func (c *Cache) store(s *shard, order *models.Order, size int64, expiresAt time.Time) {
+	c.forgetMissing(s, order.OrderUID)
+	s.items[order.OrderUID] = &CacheItem{
 		Order:     order,
-		ExpiresAt: time.Now().Add(c.ttl),
+		ExpiresAt: expiresAt,
 		Size:      size,
 	}
-	c.currentSize += size
-	metrics.CacheSize.Set(float64(c.currentSize))
-	metrics.CacheItems.Set(float64(len(c.items)))
+	c.index.add(order)
+	s.policy.Add(order.OrderUID)
+	s.currentSize += size
+	c.size.Add(size)
+	c.count.Add(1)
 }
 
 func (c *Cache) Get(uid string) (*models.Order, bool) {
-	c.RLock()
-	defer c.RUnlock()
-
-	item, exists := c.items[uid]
-	if !exists || time.Now().After(item.ExpiresAt) {
-		c.misses++
+	order, ok := c.get(uid)
+	if !ok {
+		c.misses.Add(1)
 		metrics.CacheMisses.Inc()
 		return nil, false
 	}
 
-	c.hits++
+	c.hits.Add(1)
 	metrics.CacheHits.Inc()
+	return order, true
+}
+
+// get returns an unexpired order without counting a hit or miss.
+func (c *Cache) get(uid string) (*models.Order, bool) {
+	// A hit updates the eviction policy, so even reads take the write lock.
+	s := c.shardFor(uid)
+	s.Lock()
+	defer s.Unlock()
+
+	if s.admitter != nil {
+		s.admitter.Record(uid)
+	}
+
+	item, exists := s.items[uid]
+	if !exists || time.Now().After(item.ExpiresAt) {
+		return nil, false
+	}
+
+	s.policy.Access(uid)
 	return item.Order, true
 }
 
-func (c *Cache) Restore(orders []*models.Order) {
-	c.Lock()
-	defer c.Unlock()
+// Refresh brings the cache in line with orders freshly loaded from the
+// database. Cached copies are replaced unless they have a newer version.
+// Orders that are not cached stay out, but they are no longer remembered as
+// missing, and cached lookup results they would belong to are no longer
+// trusted to be complete.
+func (c *Cache) Refresh(orders []*models.Order) {
+	for _, order := range orders {
+		s := c.shardFor(order.OrderUID)
+		s.Lock()
+		if item, exists := s.items[order.OrderUID]; exists {
+			if item.Order.Version <= order.Version {
+				c.set(s, order)
+			}
+		} else {
+			c.forgetMissing(s, order.OrderUID)
+			c.index.invalidate(order)
+		}
+		s.Unlock()
+		c.shrink(order.OrderUID)
+	}
+}
+
+// Remove drops the order with uid, which no longer exists.
+func (c *Cache) Remove(uid string) {
+	s := c.shardFor(uid)
+	s.Lock()
+	defer s.Unlock()
+
+	c.remove(s, uid, false)
+	c.updateMetrics()
+}
+
+// Invalidate drops the orders with uids, which changed to versions the
+// caller could not load. They are no longer remembered as missing, and
+// since their new keys are unknown, no cached lookup result is trusted to
+// be complete any more.
+func (c *Cache) Invalidate(uids []string) {
+	for _, uid := range uids {
+		s := c.shardFor(uid)
+		s.Lock()
+		c.remove(s, uid, true)
+		c.forgetMissing(s, uid)
+		s.Unlock()
+	}
+	if len(uids) > 0 {
+		c.index.invalidateAll()
+	}
+	c.updateMetrics()
+}
+
+// Version returns the version of the cached order with uid.
+func (c *Cache) Version(uid string) (int, bool) {
+	s := c.shardFor(uid)
+	s.Lock()
+	defer s.Unlock()
+
+	item, exists := s.items[uid]
+	if !exists {
+		return 0, false
+	}
+	return item.Order.Version, true
+}
+
+// LatestUpdate returns the latest UpdatedAt of the cached orders, or the
+// zero time if the cache is empty.
+func (c *Cache) LatestUpdate() time.Time {
+	var latest time.Time
+	for _, s := range c.shards {
+		s.Lock()
+		for _, item := range s.items {
+			if item.Order.UpdatedAt.After(latest) {
+				latest = item.Order.UpdatedAt
+			}
+		}
+		s.Unlock()
+	}
+	return latest
+}
+
+// Restore adds orders without evicting anything. Orders that do not fit
+// into the remaining budget of the cache are skipped, and so are orders
+// already cached with the same or a newer version. It returns the number of
+// orders stored and reports whether all orders fitted.
+func (c *Cache) Restore(orders []*models.Order) (int, bool) {
+	defer c.updateMetrics()
 
+	added, all := 0, true
+	expiresAt := time.Now().Add(c.ttl)
 	for _, order := range orders {
-		size := int64(len(order.OrderUID)) + int64(len(order.TrackNumber)) + 1024
-		c.items[order.OrderUID] = &CacheItem{
-			Order:     order,
-			ExpiresAt: time.Now().Add(c.ttl),
-			Size:      size,
+		s := c.shardFor(order.OrderUID)
+		s.Lock()
+		stored, fits := c.restore(s, order, expiresAt)
+		s.Unlock()
+		if stored {
+			added++
+		}
+		if !fits {
+			all = false
 		}
-		c.currentSize += size
 	}
-	metrics.CacheSize.Set(float64(c.currentSize))
-	metrics.CacheItems.Set(float64(len(c.items)))
+
+	return added, all
 }
 
-func (c *Cache) evictOldest() {
-	var oldestKey string
-	var oldestTime time.Time
+// restore stores order unless a cached copy is at least as new. It reports
+// whether order was stored and whether it fitted.
+func (c *Cache) restore(s *shard, order *models.Order, expiresAt time.Time) (stored, fits bool) {
+	// A snapshot or warm-up chunk may be older than an order stored since.
+	var existingSize int64
+	existing, exists := s.items[order.OrderUID]
+	if exists {
+		if existing.Order.Version >= order.Version {
+			return false, true
+		}
+		existingSize = existing.Size
+	}
+
+	size := c.sizeOf(order)
+	if !c.reserve(size - existingSize) {
+		return false, false
+	}
+
+	if exists {
+		c.remove(s, order.OrderUID, false)
+	}
+	c.store(s, order, size, expiresAt)
+	c.size.Add(existingSize - size)
+	return true, true
+}
 
-	for key, item := range c.items {
-		if oldestTime.IsZero() || item.ExpiresAt.Before(oldestTime) {
-			oldestKey = key
-			oldestTime = item.ExpiresAt
+// reserve adds delta to the size of the cache if that keeps it within the
+// budget, so that restores into different shards cannot together overrun
+// it. The caller gives the reservation back once it has stored the order.
+func (c *Cache) reserve(delta int64) bool {
+	for {
+		size := c.size.Load()
+		if size+delta > c.maxSize {
+			return false
+		}
+		if c.size.CompareAndSwap(size, size+delta) {
+			return true
 		}
 	}
+}
+
+// evict removes the entry of s chosen by its eviction policy and reports
+// whether there was one.
+func (c *Cache) evict(s *shard) bool {
+	key, ok := s.policy.Victim()
+	if !ok {
+		return false
+	}
+
+	c.remove(s, key, true)
+	metrics.CacheEvictions.WithLabelValues(s.policy.Name()).Inc()
+	return true
+}
 
-	if oldestKey != "" {
-		c.currentSize -= c.items[oldestKey].Size
-		delete(c.items, oldestKey)
+// remove drops a stored entry. evicted tells the index whether the order
+// still exists elsewhere.
+func (c *Cache) remove(s *shard, key string, evicted bool) {
+	item, exists := s.items[key]
+	if !exists {
+		s.policy.Remove(key)
+		return
 	}
-	metrics.CacheSize.Set(float64(c.currentSize))
-	metrics.CacheItems.Set(float64(len(c.items)))
+
+	s.currentSize -= item.Size
+	c.size.Add(-item.Size)
+	c.count.Add(-1)
+	c.index.remove(item.Order, evicted)
+	s.policy.Remove(key)
+	delete(s.items, key)
+}
+
+func (c *Cache) updateMetrics() {
+	metrics.CacheSize.Set(float64(c.size.Load()))
+	metrics.CacheItems.Set(float64(c.count.Load()))
 }
 
 func (c *Cache) cleanup() {
@@ -121,21 +434,23 @@ func (c *Cache) cleanup() {
 	for {
 		select {
 		case <-ticker.C:
-			c.Lock()
 			now := time.Now()
-			for key, item := range c.items {
-				if now.After(item.ExpiresAt) {
-					c.currentSize -= item.Size
-					delete(c.items, key)
+			for _, s := range c.shards {
+				s.Lock()
+				for key, item := range s.items {
+					if now.After(item.ExpiresAt) {
+						c.remove(s, key, true)
+					}
 				}
+				c.dropExpiredMissing(s, now)
+				s.Unlock()
 			}
-			c.Unlock()
+			c.updateMetrics()
+			metrics.CacheNegativeItems.Set(float64(c.negativeCount.Load()))
 		case <-c.stopChan:
 			return
 		}
 	}
-
-	metrics.CacheItems.Set(float64(len(c.items)))
 }
 
 func (c *Cache) Stop() {
@@ -143,15 +458,11 @@ func (c *Cache) Stop() {
 }
 
 func (c *Cache) CurrentSize() int64 {
-	c.RLock()
-	defer c.RUnlock()
-	return c.currentSize
+	return c.size.Load()
 }
 
 func (c *Cache) ItemsCount() int {
-	c.RLock()
-	defer c.RUnlock()
-	return len(c.items)
+	return int(c.count.Load())
 }
 
 func (c *Cache) MaxSize() int64 {
@@ -159,32 +470,47 @@ func (c *Cache) MaxSize() int64 {
 }
 
 func (c *Cache) Hits() int64 {
-	c.RLock()
-	defer c.RUnlock()
-	return c.hits
+	return c.hits.Load()
 }
 
 func (c *Cache) Misses() int64 {
-	c.RLock()
-	defer c.RUnlock()
-	return c.misses
+	return c.misses.Load()
 }
 
 func (c *Cache) HitRatio() float64 {
-	c.RLock()
-	defer c.RUnlock()
-	total := c.hits + c.misses
+	hits, misses := c.hits.Load(), c.misses.Load()
+	total := hits + misses
 	if total == 0 {
 		return 0
 	}
-	return float64(c.hits) / float64(total)
+	return float64(hits) / float64(total)
 }
 
 func (c *Cache) Clear() {
-	c.Lock()
-	defer c.Unlock()
-	c.items = make(map[string]*CacheItem)
-	c.currentSize = 0
-	c.hits = 0
-	c.misses = 0
+	for _, s := range c.shards {
+		s.Lock()
+		defer s.Unlock()
+	}
+
+	for _, s := range c.shards {
+		for _, item := range s.items {
+			c.size.Add(-item.Size)
+			c.count.Add(-1)
+		}
+		s.items = make(map[string]*CacheItem)
+		// A new policy also forgets the frequencies an admission filter
+		// recorded.
+		s.policy = c.newPolicy()
+		s.admitter, _ = s.policy.(Admitter)
+		s.currentSize = 0
+		generation := s.negative.generation
+		s.negative = newNegativeCache(s.negative.ttl, s.negative.maxEntries)
+		s.negative.generation = generation + 1
+	}
+	c.negativeCount.Store(0)
+	metrics.CacheNegativeItems.Set(0)
+	c.index.clear()
+	c.hits.Store(0)
+	c.misses.Store(0)
+	c.updateMetrics()
 }
@@ -2,13 +2,21 @@ package cache
 
 import (
 	"L0/internal/model"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/goccy/go-json"
 	"github.com/stretchr/testify/assert"
 )
 
+// smallSize is the size of the small orders most tests use.
+var smallSize = StructSize(&models.Order{OrderUID: "0", TrackNumber: "test"})
+
 func TestCache_SetAndGet(t *testing.T) {
 	cache := New(1024*1024, time.Minute)
 
@@ -40,10 +48,8 @@ func TestCache_Expiration(t *testing.T) {
 }
 
 func TestCache_Eviction(t *testing.T) {
-	// Create a small cache that can hold about 2 items
-	// Each item is approximately: 1 (OrderUID) + 4 (TrackNumber) + 1024 = 1029 bytes
-	// So for 2 items we need at least 2058 bytes
-	cache := New(2058, time.Minute)
+	// Create a small cache that can hold 2 items
+	cache := New(2*smallSize, time.Minute, WithShards(1))
 
 	// Add 3 items - the oldest should be evicted
 	for i := 0; i < 3; i++ {
@@ -147,3 +153,747 @@ func TestCache_Clear(t *testing.T) {
 	_, exists := cache.Get("test-order")
 	assert.False(t, exists)
 }
+
+func TestCache_Lookup(t *testing.T) {
+	cache := New(1024*1024, time.Minute)
+
+	order := &models.Order{
+		OrderUID:    "test-order",
+		TrackNumber: "test-track",
+		Items:       []models.Item{{ChrtID: 1, NmID: 2, TrackNumber: "item-track"}},
+	}
+
+	// Orders cached one by one do not make a lookup complete
+	cache.Set(order)
+	_, ok := cache.Lookup(ByTrackNumber, "test-track")
+	assert.False(t, ok)
+
+	cache.SetLookup(ByTrackNumber, "test-track", []*models.Order{order})
+	orders, ok := cache.Lookup(ByTrackNumber, "test-track")
+	assert.True(t, ok)
+	assert.Equal(t, []*models.Order{order}, orders)
+
+	// An updated order moves to its new track number
+	updated := &models.Order{OrderUID: "test-order", TrackNumber: "new-track"}
+	cache.Set(updated)
+	orders, ok = cache.Lookup(ByTrackNumber, "test-track")
+	assert.True(t, ok)
+	assert.Empty(t, orders)
+
+	cache.SetLookup(ByChrtID, IntKey(1), []*models.Order{order})
+	orders, ok = cache.Lookup(ByChrtID, IntKey(1))
+	assert.True(t, ok)
+	assert.Len(t, orders, 1)
+	_, ok = cache.Lookup(ByNmID, IntKey(2))
+	assert.False(t, ok)
+}
+
+func TestCache_LookupAfterEviction(t *testing.T) {
+	cache := New(2*smallSize, time.Minute, WithShards(1))
+
+	first := &models.Order{OrderUID: "1", TrackNumber: "test"}
+	second := &models.Order{OrderUID: "2", TrackNumber: "test"}
+	cache.SetLookup(ByTrackNumber, "test", []*models.Order{first, second})
+
+	_, ok := cache.Lookup(ByTrackNumber, "test")
+	assert.True(t, ok)
+
+	// Evicting one of the orders makes the cached result incomplete
+	cache.Set(&models.Order{OrderUID: "3", TrackNumber: "other"})
+	_, ok = cache.Lookup(ByTrackNumber, "test")
+	assert.False(t, ok)
+}
+
+func TestCache_LookupAfterOversizedUpdate(t *testing.T) {
+	cache := New(2*smallSize, time.Minute, WithShards(1))
+
+	first := &models.Order{OrderUID: "1", TrackNumber: "test"}
+	second := &models.Order{OrderUID: "2", TrackNumber: "test"}
+	cache.SetLookup(ByTrackNumber, "test", []*models.Order{first, second})
+
+	// The new version is too large to cache but still matches the key
+	updated := &models.Order{OrderUID: "1", TrackNumber: "test"}
+	for i := 0; i < 100; i++ {
+		updated.Items = append(updated.Items, models.Item{ChrtID: i})
+	}
+	cache.Set(updated)
+
+	_, exists := cache.Get("1")
+	assert.False(t, exists)
+	_, ok := cache.Lookup(ByTrackNumber, "test")
+	assert.False(t, ok)
+}
+
+func TestCache_LookupAfterClear(t *testing.T) {
+	cache := New(1024*1024, time.Minute)
+
+	order := &models.Order{OrderUID: "test-order", TrackNumber: "test-track"}
+	cache.SetLookup(ByTrackNumber, "test-track", []*models.Order{order})
+
+	cache.Clear()
+	_, ok := cache.Lookup(ByTrackNumber, "test-track")
+	assert.False(t, ok)
+}
+
+func TestCache_RestoreRespectsMaxSize(t *testing.T) {
+	// Room for two items
+	cache := New(2*smallSize, time.Minute, WithShards(1))
+
+	orders := []*models.Order{
+		{OrderUID: "0", TrackNumber: "test"},
+		{OrderUID: "1", TrackNumber: "test"},
+		{OrderUID: "2", TrackNumber: "test"},
+	}
+
+	added, all := cache.Restore(orders)
+	assert.Equal(t, 2, added)
+	assert.False(t, all)
+	assert.Equal(t, 2, cache.ItemsCount())
+	assert.True(t, cache.CurrentSize() <= cache.MaxSize())
+
+	_, exists := cache.Get("2")
+	assert.False(t, exists)
+
+	// Replacing a cached order needs no additional room
+	newer := *orders[0]
+	newer.Version = 1
+	added, all = cache.Restore([]*models.Order{&newer})
+	assert.Equal(t, 1, added)
+	assert.True(t, all)
+	assert.Equal(t, 2, cache.ItemsCount())
+	version, _ := cache.Version("0")
+	assert.Equal(t, 1, version)
+}
+
+func TestCache_RestoreKeepsNewerVersion(t *testing.T) {
+	cache := New(1024*1024, time.Minute)
+
+	// A warm-up chunk read before the order was updated arrives afterwards.
+	cache.Set(&models.Order{OrderUID: "order-1", TrackNumber: "updated", Version: 2})
+	added, all := cache.Restore([]*models.Order{
+		{OrderUID: "order-1", TrackNumber: "stale", Version: 1},
+		{OrderUID: "order-2", TrackNumber: "track-2", Version: 1},
+	})
+	assert.Equal(t, 1, added)
+	assert.True(t, all)
+
+	order, exists := cache.Get("order-1")
+	if assert.True(t, exists) {
+		assert.Equal(t, "updated", order.TrackNumber)
+	}
+	assert.Equal(t, 2, cache.ItemsCount())
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	// Room for two items
+	cache := New(2*smallSize, time.Minute, WithShards(1))
+
+	cache.Set(&models.Order{OrderUID: "0", TrackNumber: "test"})
+	cache.Set(&models.Order{OrderUID: "1", TrackNumber: "test"})
+
+	// Reading "0" makes "1" the least recently used one
+	_, exists := cache.Get("0")
+	assert.True(t, exists)
+
+	cache.Set(&models.Order{OrderUID: "2", TrackNumber: "test"})
+
+	_, exists = cache.Get("1")
+	assert.False(t, exists)
+	_, exists = cache.Get("0")
+	assert.True(t, exists)
+	_, exists = cache.Get("2")
+	assert.True(t, exists)
+}
+
+func TestCache_EvictsUntilItFits(t *testing.T) {
+	// Room for three and a half items
+	cache := New(3*smallSize+smallSize/2, time.Minute, WithShards(1))
+
+	for i := 0; i < 3; i++ {
+		cache.Set(&models.Order{OrderUID: strconv.Itoa(i), TrackNumber: "test"})
+	}
+
+	// Almost two small items in size, so two of them must go
+	big := &models.Order{OrderUID: "b", TrackNumber: strings.Repeat("x", int(smallSize))}
+	cache.Set(big)
+
+	_, exists := cache.Get("b")
+	assert.True(t, exists)
+	assert.Equal(t, 2, cache.ItemsCount())
+	assert.LessOrEqual(t, cache.CurrentSize(), cache.MaxSize())
+
+	_, exists = cache.Get("2")
+	assert.True(t, exists)
+}
+
+func TestCache_DoesNotStoreOversizedItem(t *testing.T) {
+	cache := New(smallSize+smallSize/2, time.Minute, WithShards(1))
+
+	cache.Set(&models.Order{OrderUID: "0", TrackNumber: "test"})
+	cache.Set(&models.Order{OrderUID: "b", TrackNumber: strings.Repeat("x", int(smallSize))})
+
+	_, exists := cache.Get("b")
+	assert.False(t, exists)
+	_, exists = cache.Get("0")
+	assert.True(t, exists)
+}
+
+func TestCache_LFU(t *testing.T) {
+	newPolicy, err := PolicyByName(PolicyLFU)
+	assert.NoError(t, err)
+	cache := New(2*smallSize, time.Minute, WithShards(1), WithEvictionPolicy(newPolicy))
+
+	cache.Set(&models.Order{OrderUID: "0", TrackNumber: "test"})
+	cache.Set(&models.Order{OrderUID: "1", TrackNumber: "test"})
+
+	// "0" is read more often, although "1" is read last
+	cache.Get("0")
+	cache.Get("0")
+	cache.Get("1")
+
+	cache.Set(&models.Order{OrderUID: "2", TrackNumber: "test"})
+
+	_, exists := cache.Get("1")
+	assert.False(t, exists)
+	_, exists = cache.Get("0")
+	assert.True(t, exists)
+	_, exists = cache.Get("2")
+	assert.True(t, exists)
+}
+
+func TestCache_TinyLFU(t *testing.T) {
+	newPolicy, err := PolicyByName(PolicyTinyLFU)
+	assert.NoError(t, err)
+	cache := New(2*smallSize, time.Minute, WithShards(1), WithEvictionPolicy(newPolicy))
+
+	cache.Set(&models.Order{OrderUID: "0", TrackNumber: "test"})
+	cache.Set(&models.Order{OrderUID: "1", TrackNumber: "test"})
+	for i := 0; i < 2; i++ {
+		cache.Get("0")
+		cache.Get("1")
+	}
+
+	// An order used less often than the victim is not admitted.
+	cache.Set(&models.Order{OrderUID: "2", TrackNumber: "test"})
+	assert.Equal(t, 2, cache.ItemsCount())
+	_, exists := cache.Get("2")
+	assert.False(t, exists)
+
+	// Lookups count even when they miss.
+	for i := 0; i < 4; i++ {
+		cache.Get("3")
+	}
+	cache.Set(&models.Order{OrderUID: "3", TrackNumber: "test"})
+
+	_, exists = cache.Get("0")
+	assert.False(t, exists)
+	_, exists = cache.Get("1")
+	assert.True(t, exists)
+	_, exists = cache.Get("3")
+	assert.True(t, exists)
+
+	// Cached orders are always replaced.
+	cache.Set(&models.Order{OrderUID: "1", TrackNumber: "updated"})
+	order, exists := cache.Get("1")
+	if assert.True(t, exists) {
+		assert.Equal(t, "updated", order.TrackNumber)
+	}
+}
+
+func TestCache_TinyLFUAfterClear(t *testing.T) {
+	newPolicy, err := PolicyByName(PolicyTinyLFU)
+	assert.NoError(t, err)
+	cache := New(2*smallSize, time.Minute, WithShards(1), WithEvictionPolicy(newPolicy))
+
+	for i := 0; i < 4; i++ {
+		cache.Get("2")
+	}
+	cache.Clear()
+
+	// The lookups before the clear no longer get the order admitted.
+	cache.Set(&models.Order{OrderUID: "0", TrackNumber: "test"})
+	cache.Set(&models.Order{OrderUID: "1", TrackNumber: "test"})
+	cache.Set(&models.Order{OrderUID: "2", TrackNumber: "test"})
+	_, exists := cache.Get("2")
+	assert.False(t, exists)
+	assert.Equal(t, 2, cache.ItemsCount())
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(10)
+	assert.Len(t, s.rows[0], 16)
+
+	for i := 0; i < 5; i++ {
+		s.increment("a")
+	}
+	assert.Equal(t, uint8(5), s.estimate("a"))
+	assert.Equal(t, uint8(0), s.estimate("b"))
+
+	// Counters saturate and are halved after sketchSamples increments per
+	// counter.
+	for i := 5; i < sketchSamples*16-1; i++ {
+		s.increment("a")
+	}
+	assert.Equal(t, uint8(sketchMax), s.estimate("a"))
+	s.increment("a")
+	assert.Equal(t, uint8(sketchMax/2), s.estimate("a"))
+}
+
+func TestLFU_Victim(t *testing.T) {
+	p := NewLFU()
+
+	_, ok := p.Victim()
+	assert.False(t, ok)
+
+	p.Add("a")
+	p.Add("b")
+	p.Add("c")
+	p.Access("a")
+	p.Access("b")
+	p.Access("a")
+
+	// c: 1, b: 2, a: 3
+	victim, _ := p.Victim()
+	assert.Equal(t, "c", victim)
+
+	p.Remove("c")
+	victim, _ = p.Victim()
+	assert.Equal(t, "b", victim)
+
+	// Equal frequencies fall back to the least recently used key
+	p.Access("b")
+	victim, _ = p.Victim()
+	assert.Equal(t, "a", victim)
+	p.Access("a")
+	victim, _ = p.Victim()
+	assert.Equal(t, "b", victim)
+
+	p.Remove("b")
+	p.Remove("a")
+	_, ok = p.Victim()
+	assert.False(t, ok)
+}
+
+func TestPolicyByName_Unknown(t *testing.T) {
+	_, err := PolicyByName("fifo")
+	assert.Error(t, err)
+}
+
+func TestStructSize(t *testing.T) {
+	order := &models.Order{OrderUID: "order-1", TrackNumber: "track-1"}
+	base := StructSize(order)
+	assert.Equal(t, entrySize+int64(len("order-1")+len("track-1")), base)
+
+	// Nested strings count
+	order.Delivery.Address = strings.Repeat("a", 100)
+	order.Payment.Bank = strings.Repeat("b", 10)
+	assert.Equal(t, base+110, StructSize(order))
+
+	// Every item counts with its strings
+	order.Items = []models.Item{
+		{Name: strings.Repeat("n", 50)},
+		{Brand: strings.Repeat("b", 20), Rid: "rid"},
+	}
+	assert.Equal(t, base+110+2*itemSize+73, StructSize(order))
+}
+
+func TestJSONSize(t *testing.T) {
+	order := &models.Order{
+		OrderUID:    "order-1",
+		TrackNumber: "track-1",
+		Items:       []models.Item{{Name: "item"}, {Name: "another item"}},
+	}
+
+	data, err := json.Marshal(order)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), JSONSize(order))
+
+	order.Items = append(order.Items, models.Item{Name: strings.Repeat("n", 100)})
+	assert.Greater(t, JSONSize(order), int64(len(data))+100)
+}
+
+func TestCache_SizeGrowsWithItems(t *testing.T) {
+	for _, name := range []string{SizeStruct, SizeJSON} {
+		t.Run(name, func(t *testing.T) {
+			sizeOf, err := NewSizeEstimator(name)
+			assert.NoError(t, err)
+			cache := New(1024*1024, time.Minute, WithSizeEstimator(sizeOf))
+
+			small := &models.Order{OrderUID: "small", TrackNumber: "track"}
+			large := &models.Order{OrderUID: "large", TrackNumber: "track"}
+			for i := 0; i < 100; i++ {
+				large.Items = append(large.Items, models.Item{Name: "item " + strconv.Itoa(i), Brand: "brand"})
+			}
+
+			cache.Set(small)
+			smallTotal := cache.CurrentSize()
+			assert.Equal(t, sizeOf(small), smallTotal)
+
+			cache.Set(large)
+			assert.Equal(t, smallTotal+sizeOf(large), cache.CurrentSize())
+			assert.Greater(t, sizeOf(large), 10*smallTotal)
+
+			// Replacing an order accounts for the new size only
+			cache.Set(&models.Order{OrderUID: "large", TrackNumber: "track"})
+			assert.Equal(t, 2*smallTotal, cache.CurrentSize())
+		})
+	}
+
+	_, err := NewSizeEstimator("gob")
+	assert.Error(t, err)
+}
+
+// TestCache_Concurrent is meant to be run with -race.
+func TestCache_Concurrent(t *testing.T) {
+	cache := New(50*smallSize, time.Minute, WithShards(4))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				order := &models.Order{
+					OrderUID:    strconv.Itoa((w*500 + i) % 100),
+					TrackNumber: "test",
+					Items:       []models.Item{{ChrtID: i % 7}},
+				}
+				switch i % 5 {
+				case 0:
+					cache.Set(order)
+				case 1:
+					cache.SetLookup(ByChrtID, IntKey(i%7), []*models.Order{order})
+				case 2:
+					cache.Lookup(ByChrtID, IntKey(i%7))
+				case 3:
+					cache.Restore([]*models.Order{order})
+				default:
+					cache.Get(order.OrderUID)
+				}
+				if i%100 == 0 {
+					cache.HitRatio()
+					cache.CurrentSize()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, cache.CurrentSize(), cache.MaxSize())
+	assert.LessOrEqual(t, cache.ItemsCount(), 100)
+
+	var size int64
+	var items int
+	for _, s := range cache.shards {
+		size += s.currentSize
+		items += len(s.items)
+	}
+	assert.Equal(t, size, cache.CurrentSize())
+	assert.Equal(t, items, cache.ItemsCount())
+}
+
+func TestCache_HitsAndMisses(t *testing.T) {
+	cache := New(1024*1024, time.Minute)
+	cache.Set(&models.Order{OrderUID: "0", TrackNumber: "test"})
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				cache.Get("0")
+				cache.Get("missing")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(800), cache.Hits())
+	assert.Equal(t, int64(800), cache.Misses())
+	assert.Equal(t, 0.5, cache.HitRatio())
+
+	cache.Clear()
+	assert.Equal(t, int64(0), cache.Hits())
+	assert.Equal(t, 0, cache.ItemsCount())
+	assert.Equal(t, int64(0), cache.CurrentSize())
+}
+
+func TestCache_ShardsShareBudget(t *testing.T) {
+	cache := New(4*smallSize, time.Minute, WithShards(4))
+
+	for i := 0; i < 100; i++ {
+		cache.Set(&models.Order{OrderUID: strconv.Itoa(i % 10), TrackNumber: "test"})
+	}
+	assert.Equal(t, 4, cache.ItemsCount())
+	assert.Equal(t, 4*smallSize, cache.CurrentSize())
+
+	// An order larger than a quarter of the budget still fits, by evicting
+	// orders of other shards
+	large := &models.Order{OrderUID: "large", TrackNumber: "test"}
+	for StructSize(large) <= 2*smallSize {
+		large.Items = append(large.Items, models.Item{})
+	}
+	cache.Set(large)
+
+	_, exists := cache.Get("large")
+	assert.True(t, exists)
+	assert.LessOrEqual(t, cache.CurrentSize(), cache.MaxSize())
+}
+
+func TestCache_RestoreFillsTotalBudget(t *testing.T) {
+	cache := New(4*smallSize, time.Minute, WithShards(4))
+
+	// Orders 0 to 9 are spread unevenly over the shards
+	var orders []*models.Order
+	for i := 0; i < 10; i++ {
+		orders = append(orders, &models.Order{OrderUID: strconv.Itoa(i), TrackNumber: "test"})
+	}
+	added, all := cache.Restore(orders)
+
+	assert.Equal(t, 4, added)
+	assert.False(t, all)
+	assert.Equal(t, 4*smallSize, cache.CurrentSize())
+
+	// Orders within the budget all fit, whichever shards they fall into
+	cache = New(4*smallSize, time.Minute, WithShards(4))
+	added, all = cache.Restore(orders[:4])
+	assert.Equal(t, 4, added)
+	assert.True(t, all)
+}
+
+func TestCache_Missing(t *testing.T) {
+	cache := New(1024*1024, time.Minute, WithNegativeCache(20*time.Millisecond, 100))
+
+	cache.SetMissing("unknown", cache.MissingToken("unknown"))
+	assert.True(t, cache.Missing("unknown"))
+	assert.False(t, cache.Missing("other"))
+
+	// Entries expire on their own TTL
+	time.Sleep(30 * time.Millisecond)
+	assert.False(t, cache.Missing("unknown"))
+
+	// Storing the order drops its entry
+	cache.SetMissing("unknown", cache.MissingToken("unknown"))
+	cache.Set(&models.Order{OrderUID: "unknown", TrackNumber: "test"})
+	assert.False(t, cache.Missing("unknown"))
+
+	cache.SetMissing("gone", cache.MissingToken("gone"))
+	cache.Clear()
+	assert.False(t, cache.Missing("gone"))
+}
+
+func TestCache_MissingStaleToken(t *testing.T) {
+	cache := New(1024*1024, time.Minute, WithNegativeCache(time.Minute, 100))
+
+	// The order is stored while the database lookup that missed it runs
+	token := cache.MissingToken("new")
+	cache.Set(&models.Order{OrderUID: "new", TrackNumber: "test"})
+	cache.SetMissing("new", token)
+
+	assert.False(t, cache.Missing("new"))
+	_, exists := cache.Get("new")
+	assert.True(t, exists)
+}
+
+func TestCache_MissingMaxEntries(t *testing.T) {
+	cache := New(1024*1024, time.Minute, WithShards(1), WithNegativeCache(time.Minute, 2))
+
+	for i := 0; i < 3; i++ {
+		uid := strconv.Itoa(i)
+		cache.SetMissing(uid, cache.MissingToken(uid))
+	}
+
+	// The oldest entry made room for the newest one
+	assert.False(t, cache.Missing("0"))
+	assert.True(t, cache.Missing("1"))
+	assert.True(t, cache.Missing("2"))
+}
+
+func TestCache_MissingDisabled(t *testing.T) {
+	cache := New(1024*1024, time.Minute)
+
+	cache.SetMissing("unknown", cache.MissingToken("unknown"))
+	assert.False(t, cache.Missing("unknown"))
+}
+
+func TestCache_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	cache := New(1024*1024, time.Minute)
+	order := &models.Order{
+		OrderUID:    "order-1",
+		TrackNumber: "track-1",
+		Items:       []models.Item{{ChrtID: 1, Name: "item"}},
+	}
+	cache.Set(order)
+	cache.Set(&models.Order{OrderUID: "order-2", TrackNumber: "track-2"})
+	expiresAt := cache.shardFor("order-1").items["order-1"].ExpiresAt
+
+	before := time.Now()
+	assert.NoError(t, cache.SaveSnapshot(path))
+
+	restored := New(1024*1024, time.Minute)
+	savedAt, err := restored.LoadSnapshot(path)
+	assert.NoError(t, err)
+	assert.False(t, savedAt.Before(before))
+	assert.Equal(t, 2, restored.ItemsCount())
+	assert.Equal(t, cache.CurrentSize(), restored.CurrentSize())
+
+	got, exists := restored.Get("order-1")
+	assert.True(t, exists)
+	assert.Equal(t, order, got)
+	assert.True(t, expiresAt.Equal(restored.shardFor("order-1").items["order-1"].ExpiresAt))
+
+	// The secondary index is rebuilt as well
+	restored.SetLookup(ByChrtID, IntKey(1), []*models.Order{got})
+	_, ok := restored.Lookup(ByChrtID, IntKey(1))
+	assert.True(t, ok)
+}
+
+func TestCache_LatestUpdate(t *testing.T) {
+	cache := New(1024*1024, time.Minute)
+	assert.True(t, cache.LatestUpdate().IsZero())
+
+	latest := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	cache.Set(&models.Order{OrderUID: "order-1", UpdatedAt: latest.Add(-time.Hour)})
+	cache.Set(&models.Order{OrderUID: "order-2", UpdatedAt: latest})
+	cache.Set(&models.Order{OrderUID: "order-3"})
+	assert.Equal(t, latest, cache.LatestUpdate())
+}
+
+func TestCache_SnapshotSkipsExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	cache := New(1024*1024, 20*time.Millisecond)
+	cache.Set(&models.Order{OrderUID: "order-1", TrackNumber: "track-1"})
+	assert.NoError(t, cache.SaveSnapshot(path))
+
+	time.Sleep(30 * time.Millisecond)
+	restored := New(1024*1024, time.Minute)
+	_, err := restored.LoadSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, restored.ItemsCount())
+}
+
+func TestCache_SnapshotInvalid(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.snapshot")
+
+	cache := New(1024*1024, time.Minute)
+	cache.Set(&models.Order{OrderUID: "order-1", TrackNumber: "track-1"})
+	assert.NoError(t, cache.SaveSnapshot(path))
+
+	valid, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	cases := map[string]func([]byte) []byte{
+		"flipped byte":   func(d []byte) []byte { d[len(d)-2] ^= 0xff; return d },
+		"truncated":      func(d []byte) []byte { return d[:len(d)-1] },
+		"other version":  func(d []byte) []byte { d[7]++; return d },
+		"not a snapshot": func([]byte) []byte { return []byte("{}") },
+	}
+	for name, change := range cases {
+		t.Run(name, func(t *testing.T) {
+			p := filepath.Join(dir, "corrupt.snapshot")
+			assert.NoError(t, os.WriteFile(p, change(append([]byte(nil), valid...)), 0o600))
+
+			restored := New(1024*1024, time.Minute)
+			_, err := restored.LoadSnapshot(p)
+			assert.ErrorIs(t, err, ErrInvalidSnapshot)
+			assert.Equal(t, 0, restored.ItemsCount())
+		})
+	}
+	_, err = New(1024*1024, time.Minute).LoadSnapshot(filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCache_Refresh(t *testing.T) {
+	cache := New(1024*1024, time.Minute)
+	cache.Set(&models.Order{OrderUID: "order-1", TrackNumber: "old"})
+
+	updated := &models.Order{OrderUID: "order-1", TrackNumber: "new"}
+	cache.Refresh([]*models.Order{updated, {OrderUID: "order-2", TrackNumber: "new"}})
+
+	got, exists := cache.Get("order-1")
+	assert.True(t, exists)
+	assert.Equal(t, updated, got)
+
+	// Orders that were not cached stay out
+	_, exists = cache.Get("order-2")
+	assert.False(t, exists)
+}
+
+func TestCache_RefreshKeepsNewerVersion(t *testing.T) {
+	cache := New(1024*1024, time.Minute)
+	newer := &models.Order{OrderUID: "order-1", TrackNumber: "newer", Version: 3}
+	cache.Set(newer)
+
+	cache.Refresh([]*models.Order{{OrderUID: "order-1", TrackNumber: "older", Version: 2}})
+
+	got, _ := cache.Get("order-1")
+	assert.Equal(t, newer, got)
+	version, ok := cache.Version("order-1")
+	assert.True(t, ok)
+	assert.Equal(t, 3, version)
+}
+
+func TestCache_RefreshUncachedOrder(t *testing.T) {
+	cache := New(1024*1024, time.Minute, WithNegativeCache(time.Minute, 100))
+
+	cached := &models.Order{OrderUID: "1", TrackNumber: "test"}
+	cache.SetLookup(ByTrackNumber, "test", []*models.Order{cached})
+	cache.SetMissing("2", cache.MissingToken("2"))
+
+	// Another process created an order with the same track number
+	cache.Refresh([]*models.Order{{OrderUID: "2", TrackNumber: "test"}})
+
+	assert.False(t, cache.Missing("2"))
+	_, ok := cache.Lookup(ByTrackNumber, "test")
+	assert.False(t, ok)
+	_, exists := cache.Get("2")
+	assert.False(t, exists)
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	cache := New(1024*1024, time.Minute, WithNegativeCache(time.Minute, 100))
+
+	first := &models.Order{OrderUID: "1", TrackNumber: "test"}
+	second := &models.Order{OrderUID: "2", TrackNumber: "test"}
+	cache.SetLookup(ByTrackNumber, "test", []*models.Order{first, second})
+	other := &models.Order{OrderUID: "3", TrackNumber: "other"}
+	cache.SetLookup(ByTrackNumber, "other", []*models.Order{other})
+	cache.SetMissing("4", cache.MissingToken("4"))
+
+	// Order 4 may now have any track number, so no lookup is complete
+	cache.Invalidate([]string{"1", "4"})
+
+	_, exists := cache.Get("1")
+	assert.False(t, exists)
+	assert.False(t, cache.Missing("4"))
+	assert.Equal(t, 2, cache.ItemsCount())
+	_, ok := cache.Lookup(ByTrackNumber, "test")
+	assert.False(t, ok)
+	_, ok = cache.Lookup(ByTrackNumber, "other")
+	assert.False(t, ok)
+}
+
+func TestCache_Remove(t *testing.T) {
+	cache := New(1024*1024, time.Minute)
+
+	first := &models.Order{OrderUID: "1", TrackNumber: "test"}
+	second := &models.Order{OrderUID: "2", TrackNumber: "test"}
+	cache.SetLookup(ByTrackNumber, "test", []*models.Order{first, second})
+
+	cache.Remove("1")
+
+	_, exists := cache.Get("1")
+	assert.False(t, exists)
+	assert.Equal(t, 1, cache.ItemsCount())
+	assert.Equal(t, smallSize, cache.CurrentSize())
+
+	// A deleted order leaves the lookup result complete
+	orders, ok := cache.Lookup(ByTrackNumber, "test")
+	assert.True(t, ok)
+	assert.Equal(t, []*models.Order{second}, orders)
+}
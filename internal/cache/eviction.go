@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+)
+
+const (
+	PolicyLRU     = "lru"
+	PolicyLFU     = "lfu"
+	PolicyTinyLFU = "tinylfu"
+)
+
+// tinyLFUWidth is the number of counters per row of the frequency sketch
+// of each shard.
+const tinyLFUWidth = 1 << 12
+
+// EvictionPolicy chooses which key a cache shard evicts when it is over
+// budget. It is called with the shard lock held, so implementations need no
+// locking of their own. All methods must be O(1).
+type EvictionPolicy interface {
+	Name() string
+	// Add records a key that has just been stored.
+	Add(key string)
+	// Access records a read of a stored key.
+	Access(key string)
+	// Remove forgets a key that is no longer stored.
+	Remove(key string)
+	// Victim returns the key to evict next without removing it.
+	Victim() (string, bool)
+}
+
+// Admitter is implemented by policies that may refuse to store a new key.
+// The cache records every lookup and store of a key, including lookups of
+// keys it does not hold, and asks Admit before it evicts victim to make
+// room for candidate. Like the EvictionPolicy methods, they are called with
+// the shard lock held.
+type Admitter interface {
+	Record(key string)
+	Admit(candidate, victim string) bool
+}
+
+// PolicyByName returns the constructor of the policy with the given name,
+// to be passed to WithEvictionPolicy.
+func PolicyByName(name string) (func() EvictionPolicy, error) {
+	switch name {
+	case PolicyLRU:
+		return func() EvictionPolicy { return NewLRU() }, nil
+	case PolicyLFU:
+		return func() EvictionPolicy { return NewLFU() }, nil
+	case PolicyTinyLFU:
+		return func() EvictionPolicy { return NewTinyLFU(tinyLFUWidth) }, nil
+	default:
+		return nil, fmt.Errorf("unknown cache eviction policy %q", name)
+	}
+}
+
+// LRU evicts the least recently used key.
+type LRU struct {
+	order *list.List
+	keys  map[string]*list.Element
+}
+
+func NewLRU() *LRU {
+	return &LRU{
+		order: list.New(),
+		keys:  make(map[string]*list.Element),
+	}
+}
+
+func (p *LRU) Name() string {
+	return PolicyLRU
+}
+
+func (p *LRU) Add(key string) {
+	if elem, ok := p.keys[key]; ok {
+		p.order.MoveToFront(elem)
+		return
+	}
+	p.keys[key] = p.order.PushFront(key)
+}
+
+func (p *LRU) Access(key string) {
+	if elem, ok := p.keys[key]; ok {
+		p.order.MoveToFront(elem)
+	}
+}
+
+func (p *LRU) Remove(key string) {
+	if elem, ok := p.keys[key]; ok {
+		p.order.Remove(elem)
+		delete(p.keys, key)
+	}
+}
+
+func (p *LRU) Victim() (string, bool) {
+	elem := p.order.Back()
+	if elem == nil {
+		return "", false
+	}
+	return elem.Value.(string), true
+}
+
+// LFU evicts the least frequently used key, and the least recently used one
+// among keys with the same frequency. Keys are kept in buckets of equal
+// frequency, so that every operation is O(1).
+type LFU struct {
+	buckets *list.List // of *lfuBucket, by ascending frequency
+	keys    map[string]*lfuEntry
+}
+
+type lfuBucket struct {
+	freq int
+	keys *list.List // of string, most recently used first
+}
+
+type lfuEntry struct {
+	bucket *list.Element
+	elem   *list.Element
+}
+
+func NewLFU() *LFU {
+	return &LFU{
+		buckets: list.New(),
+		keys:    make(map[string]*lfuEntry),
+	}
+}
+
+func (p *LFU) Name() string {
+	return PolicyLFU
+}
+
+func (p *LFU) Add(key string) {
+	if _, ok := p.keys[key]; ok {
+		p.Access(key)
+		return
+	}
+
+	front := p.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).freq != 1 {
+		front = p.buckets.PushFront(&lfuBucket{freq: 1, keys: list.New()})
+	}
+	p.keys[key] = &lfuEntry{
+		bucket: front,
+		elem:   front.Value.(*lfuBucket).keys.PushFront(key),
+	}
+}
+
+func (p *LFU) Access(key string) {
+	entry, ok := p.keys[key]
+	if !ok {
+		return
+	}
+
+	current := entry.bucket.Value.(*lfuBucket)
+	next := entry.bucket.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != current.freq+1 {
+		next = p.buckets.InsertAfter(&lfuBucket{freq: current.freq + 1, keys: list.New()}, entry.bucket)
+	}
+
+	p.unlink(entry)
+	entry.bucket = next
+	entry.elem = next.Value.(*lfuBucket).keys.PushFront(key)
+}
+
+func (p *LFU) Remove(key string) {
+	if entry, ok := p.keys[key]; ok {
+		p.unlink(entry)
+		delete(p.keys, key)
+	}
+}
+
+func (p *LFU) Victim() (string, bool) {
+	front := p.buckets.Front()
+	if front == nil {
+		return "", false
+	}
+	return front.Value.(*lfuBucket).keys.Back().Value.(string), true
+}
+
+// unlink takes the entry out of its bucket and drops the bucket if it is
+// left empty.
+func (p *LFU) unlink(entry *lfuEntry) {
+	bucket := entry.bucket.Value.(*lfuBucket)
+	bucket.keys.Remove(entry.elem)
+	if bucket.keys.Len() == 0 {
+		p.buckets.Remove(entry.bucket)
+	}
+}
+
+// TinyLFU evicts the least recently used key, but admits a new key only if
+// it was used more often than the key it would evict, so that a burst of
+// keys read once does not push out popular ones. Frequencies are estimated
+// by a count-min sketch, which also counts keys that are not stored.
+type TinyLFU struct {
+	*LRU
+	sketch *countMinSketch
+}
+
+// NewTinyLFU returns a TinyLFU with width counters per row of its sketch.
+func NewTinyLFU(width int) *TinyLFU {
+	return &TinyLFU{
+		LRU:    NewLRU(),
+		sketch: newCountMinSketch(width),
+	}
+}
+
+func (p *TinyLFU) Name() string {
+	return PolicyTinyLFU
+}
+
+func (p *TinyLFU) Record(key string) {
+	p.sketch.increment(key)
+}
+
+func (p *TinyLFU) Admit(candidate, victim string) bool {
+	return p.sketch.estimate(candidate) > p.sketch.estimate(victim)
+}
+
+const (
+	sketchDepth = 4
+	// sketchMax is the largest count a counter holds.
+	sketchMax = 15
+	// sketchSamples is the number of increments, per counter of a row,
+	// after which all counters are halved.
+	sketchSamples = 10
+)
+
+// countMinSketch estimates how often keys were seen. Each key has a
+// counter in every row, and its estimate is the smallest of them, so that
+// collisions can only make it too large. Counters are halved every
+// sketchSamples*width increments, so that the estimates follow recent use.
+type countMinSketch struct {
+	rows    [sketchDepth][]uint8
+	mask    uint64
+	added   int
+	resetAt int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	size := 1
+	for size < width {
+		size <<= 1
+	}
+
+	s := &countMinSketch{mask: uint64(size - 1), resetAt: sketchSamples * size}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size)
+	}
+	return s
+}
+
+// index returns the counter of key in row i. The rows combine the two
+// halves of a 64-bit FNV-1a hash.
+func (s *countMinSketch) index(h uint64, i int) uint64 {
+	return (h + uint64(i)*(h>>32|1)) & s.mask
+}
+
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (s *countMinSketch) increment(key string) {
+	h := hashKey(key)
+	for i, row := range s.rows {
+		if j := s.index(h, i); row[j] < sketchMax {
+			row[j]++
+		}
+	}
+
+	s.added++
+	if s.added >= s.resetAt {
+		for _, row := range s.rows {
+			for j := range row {
+				row[j] /= 2
+			}
+		}
+		s.added /= 2
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	h := hashKey(key)
+	count := uint8(sketchMax)
+	for i, row := range s.rows {
+		count = min(count, row[s.index(h, i)])
+	}
+	return count
+}
@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"L0/internal/model"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// IndexKind names a secondary key orders can be looked up by.
+type IndexKind int
+
+const (
+	ByTrackNumber IndexKind = iota
+	ByItemTrackNumber
+	ByChrtID
+	ByNmID
+)
+
+type indexKey struct {
+	kind  IndexKind
+	value string
+}
+
+type indexEntry struct {
+	uids map[string]struct{}
+	// complete is set when uids holds every order matching the key, as
+	// loaded from the database. Evicting one of them clears it.
+	complete bool
+}
+
+// secondaryIndex maps secondary keys to the uids of cached orders. Orders
+// matching one key live in different shards, so the index has a lock of its
+// own. It is taken after a shard lock, never before.
+type secondaryIndex struct {
+	mu      *sync.Mutex
+	entries map[indexKey]*indexEntry
+}
+
+func newSecondaryIndex() secondaryIndex {
+	return secondaryIndex{
+		mu:      new(sync.Mutex),
+		entries: make(map[indexKey]*indexEntry),
+	}
+}
+
+func IntKey(v int) string {
+	return strconv.Itoa(v)
+}
+
+func orderKeys(order *models.Order) []indexKey {
+	keys := []indexKey{{ByTrackNumber, order.TrackNumber}}
+	seen := map[indexKey]bool{keys[0]: true}
+
+	add := func(key indexKey) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, item := range order.Items {
+		add(indexKey{ByItemTrackNumber, item.TrackNumber})
+		add(indexKey{ByChrtID, IntKey(item.ChrtID)})
+		add(indexKey{ByNmID, IntKey(item.NmID)})
+	}
+
+	return keys
+}
+
+func (ix secondaryIndex) add(order *models.Order) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for _, key := range orderKeys(order) {
+		entry, ok := ix.entries[key]
+		if !ok {
+			entry = &indexEntry{uids: make(map[string]struct{})}
+			ix.entries[key] = entry
+		}
+		entry.uids[order.OrderUID] = struct{}{}
+	}
+}
+
+// remove drops order from the index. A replaced order no longer matches
+// its old keys, so their entries stay complete; an evicted one still does,
+// so their entries become incomplete.
+func (ix secondaryIndex) remove(order *models.Order, evicted bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for _, key := range orderKeys(order) {
+		entry, ok := ix.entries[key]
+		if !ok {
+			continue
+		}
+		delete(entry.uids, order.OrderUID)
+		if evicted {
+			entry.complete = false
+		}
+		if len(entry.uids) == 0 && !entry.complete {
+			delete(ix.entries, key)
+		}
+	}
+}
+
+// invalidate marks the entries of the keys of an order incomplete. It is
+// used for orders that exist but are not cached.
+func (ix secondaryIndex) invalidate(order *models.Order) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for _, key := range orderKeys(order) {
+		if entry, ok := ix.entries[key]; ok {
+			entry.complete = false
+			if len(entry.uids) == 0 {
+				delete(ix.entries, key)
+			}
+		}
+	}
+}
+
+// completeUIDs returns the uids of a key if they are known to be all the
+// orders matching it.
+func (ix secondaryIndex) completeUIDs(key indexKey) ([]string, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	entry, ok := ix.entries[key]
+	if !ok || !entry.complete {
+		return nil, false
+	}
+
+	uids := make([]string, 0, len(entry.uids))
+	for uid := range entry.uids {
+		uids = append(uids, uid)
+	}
+	return uids, true
+}
+
+// markComplete marks a key complete if it holds exactly the given orders.
+func (ix secondaryIndex) markComplete(key indexKey, orders []*models.Order) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	entry, ok := ix.entries[key]
+	if !ok || len(entry.uids) != len(orders) {
+		return
+	}
+	for _, order := range orders {
+		if _, ok := entry.uids[order.OrderUID]; !ok {
+			return
+		}
+	}
+	entry.complete = true
+}
+
+// invalidateAll marks every entry incomplete, keeping the uids that are
+// still cached.
+func (ix secondaryIndex) invalidateAll() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for key, entry := range ix.entries {
+		entry.complete = false
+		if len(entry.uids) == 0 {
+			delete(ix.entries, key)
+		}
+	}
+}
+
+func (ix secondaryIndex) clear() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	clear(ix.entries)
+}
+
+// Lookup returns the cached orders matching a secondary key. It reports
+// false unless the cache is known to hold every such order.
+func (c *Cache) Lookup(kind IndexKind, value string) ([]*models.Order, bool) {
+	uids, ok := c.index.completeUIDs(indexKey{kind, value})
+	if !ok {
+		return nil, false
+	}
+
+	orders := make([]*models.Order, 0, len(uids))
+	for _, uid := range uids {
+		order, ok := c.get(uid)
+		if !ok {
+			return nil, false
+		}
+		orders = append(orders, order)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].DateCreated.Equal(orders[j].DateCreated) {
+			return orders[i].DateCreated.After(orders[j].DateCreated)
+		}
+		return orders[i].OrderUID > orders[j].OrderUID
+	})
+
+	return orders, true
+}
+
+// SetLookup caches orders as the complete result of a secondary key lookup.
+func (c *Cache) SetLookup(kind IndexKind, value string, orders []*models.Order) {
+	for _, order := range orders {
+		c.Set(order)
+	}
+
+	// Storing one order may have evicted another one of the result, which
+	// leaves it out of the index entry.
+	c.index.markComplete(indexKey{kind, value}, orders)
+}
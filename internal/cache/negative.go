@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"L0/internal/metrics"
+	"container/list"
+	"time"
+)
+
+// negativeCache remembers uids known not to exist, so that repeated
+// lookups of unknown orders do not reach the database. Entries have their
+// own TTL, and the oldest ones are dropped beyond maxEntries. Storing an
+// order drops its entry. Every shard has one, guarded by the shard lock.
+type negativeCache struct {
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // of *negativeEntry, oldest first
+
+	// generation changes whenever an order is stored. See MissingToken.
+	generation uint64
+}
+
+type negativeEntry struct {
+	uid       string
+	expiresAt time.Time
+}
+
+// WithNegativeCache enables remembering unknown uids for ttl, up to
+// maxEntries of them in total. It is disabled by default.
+func WithNegativeCache(ttl time.Duration, maxEntries int) Option {
+	return func(c *Cache) {
+		c.negativeTTL = ttl
+		c.negativeMax = maxEntries
+	}
+}
+
+func newNegativeCache(ttl time.Duration, maxEntries int) negativeCache {
+	return negativeCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (n *negativeCache) enabled() bool {
+	return n.ttl > 0 && n.maxEntries > 0
+}
+
+// MissingToken returns a token to pass to SetMissing. Take it before
+// looking the order up in the database, so that an order stored in the
+// meantime is not remembered as missing.
+func (c *Cache) MissingToken(uid string) uint64 {
+	s := c.shardFor(uid)
+	s.Lock()
+	defer s.Unlock()
+
+	return s.negative.generation
+}
+
+// SetMissing remembers that no order with uid exists, unless an order was
+// stored since token was taken.
+func (c *Cache) SetMissing(uid string, token uint64) {
+	s := c.shardFor(uid)
+	s.Lock()
+	defer s.Unlock()
+
+	n := &s.negative
+	if !n.enabled() || n.generation != token {
+		return
+	}
+	if _, exists := s.items[uid]; exists {
+		return
+	}
+
+	if elem, ok := n.entries[uid]; ok {
+		c.dropMissing(n, elem)
+	}
+	for n.order.Len() >= n.maxEntries {
+		c.dropMissing(n, n.order.Front())
+	}
+	n.entries[uid] = n.order.PushBack(&negativeEntry{uid: uid, expiresAt: time.Now().Add(n.ttl)})
+	c.negativeCount.Add(1)
+	metrics.CacheNegativeItems.Set(float64(c.negativeCount.Load()))
+}
+
+// Missing reports whether uid is known not to exist.
+func (c *Cache) Missing(uid string) bool {
+	s := c.shardFor(uid)
+	s.Lock()
+	defer s.Unlock()
+
+	n := &s.negative
+	elem, ok := n.entries[uid]
+	if !ok {
+		return false
+	}
+	if time.Now().After(elem.Value.(*negativeEntry).expiresAt) {
+		c.dropMissing(n, elem)
+		metrics.CacheNegativeItems.Set(float64(c.negativeCount.Load()))
+		return false
+	}
+
+	metrics.CacheNegativeHits.Inc()
+	return true
+}
+
+// forgetMissing drops the entry of an order that is being stored.
+func (c *Cache) forgetMissing(s *shard, uid string) {
+	n := &s.negative
+	n.generation++
+	if elem, ok := n.entries[uid]; ok {
+		c.dropMissing(n, elem)
+		metrics.CacheNegativeItems.Set(float64(c.negativeCount.Load()))
+	}
+}
+
+func (c *Cache) dropMissing(n *negativeCache, elem *list.Element) {
+	delete(n.entries, elem.Value.(*negativeEntry).uid)
+	n.order.Remove(elem)
+	c.negativeCount.Add(-1)
+}
+
+// dropExpiredMissing drops the expired entries of s. They are the oldest
+// ones, as all entries have the same TTL.
+func (c *Cache) dropExpiredMissing(s *shard, now time.Time) {
+	n := &s.negative
+	for elem := n.order.Front(); elem != nil; elem = n.order.Front() {
+		if !now.After(elem.Value.(*negativeEntry).expiresAt) {
+			return
+		}
+		c.dropMissing(n, elem)
+	}
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"L0/internal/model"
+	"fmt"
+	"unsafe"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	SizeStruct = "struct"
+	SizeJSON   = "json"
+)
+
+// SizeEstimator returns the number of bytes an order is accounted for in
+// the cache budget.
+type SizeEstimator func(order *models.Order) int64
+
+// NewSizeEstimator returns the estimator with the given name.
+func NewSizeEstimator(name string) (SizeEstimator, error) {
+	switch name {
+	case SizeStruct:
+		return StructSize, nil
+	case SizeJSON:
+		return JSONSize, nil
+	default:
+		return nil, fmt.Errorf("unknown cache size estimator %q", name)
+	}
+}
+
+var (
+	entrySize   = int64(unsafe.Sizeof(CacheItem{})) + int64(unsafe.Sizeof(models.Order{}))
+	itemSize    = int64(unsafe.Sizeof(models.Item{}))
+	warningSize = int64(unsafe.Sizeof(models.Warning{}))
+)
+
+// StructSize estimates the memory held by an order and its cache entry: the
+// structs themselves plus the bytes of every string they reference.
+func StructSize(order *models.Order) int64 {
+	size := entrySize + int64(cap(order.Items))*itemSize
+
+	size += strLen(order.OrderUID, order.TrackNumber, order.Entry, order.Locale,
+		order.InternalSignature, order.CustomerID, order.DeliveryService, order.Shardkey,
+		order.OofShard)
+
+	d := &order.Delivery
+	size += strLen(d.OrderUID, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email)
+
+	p := &order.Payment
+	size += strLen(p.OrderUID, p.Transaction, p.RequestID, p.Currency, p.Provider, p.Bank)
+
+	for i := range order.Items {
+		item := &order.Items[i]
+		size += strLen(item.OrderUID, item.TrackNumber, item.Rid, item.Name, item.Size, item.Brand)
+	}
+
+	size += int64(cap(order.Warnings)) * warningSize
+	for _, w := range order.Warnings {
+		size += strLen(w.Rule, w.Field, w.Message)
+	}
+
+	return size
+}
+
+func strLen(values ...string) int64 {
+	var n int64
+	for _, v := range values {
+		n += int64(len(v))
+	}
+	return n
+}
+
+// JSONSize measures an order by the length of its JSON encoding, which is
+// what the cache serves it as.
+func JSONSize(order *models.Order) int64 {
+	data, err := json.Marshal(order)
+	if err != nil {
+		return StructSize(order)
+	}
+	return int64(len(data))
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"L0/internal/model"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// A snapshot file starts with a fixed header followed by the JSON encoded
+// entries. The header holds the magic bytes, the format version, the time
+// the snapshot was taken, and the length and CRC-32 of the payload.
+const (
+	snapshotMagic      = "L0CS"
+	snapshotVersion    = 2
+	snapshotHeaderSize = 4 + 4 + 8 + 8 + 4
+)
+
+// ErrInvalidSnapshot is returned for a snapshot file that is truncated,
+// corrupt or written by another format version.
+var ErrInvalidSnapshot = errors.New("invalid cache snapshot")
+
+type snapshotEntry struct {
+	Order     *models.Order `json:"order"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// SaveSnapshot writes the unexpired entries to path. The file is replaced
+// atomically, so a crash while saving leaves the previous snapshot intact.
+func (c *Cache) SaveSnapshot(path string) error {
+	now := time.Now()
+
+	var entries []snapshotEntry
+	for _, s := range c.shards {
+		s.Lock()
+		for _, item := range s.items {
+			if now.Before(item.ExpiresAt) {
+				entries = append(entries, snapshotEntry{Order: item.Order, ExpiresAt: item.ExpiresAt})
+			}
+		}
+		s.Unlock()
+	}
+
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("error encoding cache snapshot: %v", err)
+	}
+
+	header := make([]byte, snapshotHeaderSize)
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint32(header[4:], snapshotVersion)
+	binary.BigEndian.PutUint64(header[8:], uint64(now.UnixNano()))
+	binary.BigEndian.PutUint64(header[16:], uint64(len(payload)))
+	binary.BigEndian.PutUint32(header[24:], crc32.ChecksumIEEE(payload))
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating cache snapshot: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(header, payload...)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing cache snapshot: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing cache snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing cache snapshot: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing cache snapshot: %v", err)
+	}
+
+	return nil
+}
+
+// LoadSnapshot restores the unexpired entries of the snapshot at path with
+// their original expirations, without evicting anything, and returns the
+// time the snapshot was taken. An invalid snapshot is reported as
+// ErrInvalidSnapshot and leaves the cache untouched.
+func (c *Cache) LoadSnapshot(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	savedAt, entries, err := decodeSnapshot(data)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	defer c.updateMetrics()
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Order == nil || !now.Before(entry.ExpiresAt) {
+			continue
+		}
+
+		s := c.shardFor(entry.Order.OrderUID)
+		s.Lock()
+		c.restore(s, entry.Order, entry.ExpiresAt)
+		s.Unlock()
+	}
+
+	return savedAt, nil
+}
+
+func decodeSnapshot(data []byte) (time.Time, []snapshotEntry, error) {
+	if len(data) < snapshotHeaderSize || !bytes.Equal(data[:4], []byte(snapshotMagic)) {
+		return time.Time{}, nil, fmt.Errorf("%w: bad header", ErrInvalidSnapshot)
+	}
+	if version := binary.BigEndian.Uint32(data[4:]); version != snapshotVersion {
+		return time.Time{}, nil, fmt.Errorf("%w: version %d, want %d", ErrInvalidSnapshot, version, snapshotVersion)
+	}
+
+	savedAt := time.Unix(0, int64(binary.BigEndian.Uint64(data[8:])))
+	length := binary.BigEndian.Uint64(data[16:])
+	checksum := binary.BigEndian.Uint32(data[24:])
+
+	payload := data[snapshotHeaderSize:]
+	if uint64(len(payload)) != length {
+		return time.Time{}, nil, fmt.Errorf("%w: truncated", ErrInvalidSnapshot)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return time.Time{}, nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)
+	}
+
+	var entries []snapshotEntry
+	if err := json.Unmarshal(payload, &entries); err != nil {
+		return time.Time{}, nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	return savedAt, entries, nil
+}
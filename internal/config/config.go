@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -21,6 +22,32 @@ type Config struct {
 	LogLevel           string
 	CacheMaxSize       int64
 	CacheTTLMinutes    int
+	KafkaDLQTopic      string
+	KafkaMaxAttempts   int
+	KafkaRetryBackoff  time.Duration
+	KafkaRetryMaxDelay time.Duration
+	KafkaBatchSize     int
+	KafkaBatchTimeout  time.Duration
+	CacheWarmUpMax     int
+	CacheWarmUpHotSet  []string
+	CacheEviction      string
+	CacheSizeEstimator string
+	CacheShards        int
+	CacheNegativeTTL   time.Duration
+	CacheNegativeMax   int
+	CacheSnapshotDir   string
+	CacheSnapshotEvery time.Duration
+	InvalidationBatch  int
+	InvalidationWait   time.Duration
+	HTTPShutdownDelay  time.Duration
+	HTTPDrainTimeout   time.Duration
+	DBReadTimeout      time.Duration
+	DBWriteTimeout     time.Duration
+	DBStreamTimeout    time.Duration
+	ConsistencyRules   string
+	ValidationFile     string
+	ValidationReload   time.Duration
+	ValidationRules    *ValidationRules
 }
 
 func Load() *Config {
@@ -29,7 +56,7 @@ func Load() *Config {
 		panic("Failed to load config file: " + err.Error())
 	}
 
-	return &Config{
+	cfg := &Config{
 		KafkaBrokers:       strings.Split(getEnvRequired("KAFKA_BROKERS"), ","),
 		KafkaTopic:         getEnvRequired("KAFKA_TOPIC"),
 		KafkaPartitions:    getEnvAsIntRequired("KAFKA_PARTITIONS"),
@@ -42,7 +69,39 @@ func Load() *Config {
 		LogLevel:           getEnvRequired("LOG_LEVEL"),
 		CacheMaxSize:       getEnvAsInt64Required("CACHE_MAX_SIZE"),
 		CacheTTLMinutes:    getEnvAsIntRequired("CACHE_TTL_MINUTES"),
+		KafkaDLQTopic:      getEnvRequired("KAFKA_DLQ_TOPIC"),
+		KafkaMaxAttempts:   getEnvAsIntRequired("KAFKA_MAX_ATTEMPTS"),
+		KafkaRetryBackoff:  time.Duration(getEnvAsIntRequired("KAFKA_RETRY_BACKOFF_MS")) * time.Millisecond,
+		KafkaRetryMaxDelay: time.Duration(getEnvAsIntRequired("KAFKA_RETRY_MAX_DELAY_MS")) * time.Millisecond,
+		KafkaBatchSize:     getEnvAsIntRequired("KAFKA_BATCH_SIZE"),
+		KafkaBatchTimeout:  time.Duration(getEnvAsIntRequired("KAFKA_BATCH_TIMEOUT_MS")) * time.Millisecond,
+		CacheWarmUpMax:     getEnvAsIntRequired("CACHE_WARMUP_MAX_ORDERS"),
+		CacheWarmUpHotSet:  getEnvAsListRequired("CACHE_WARMUP_HOT_SET"),
+		CacheEviction:      getEnvRequired("CACHE_EVICTION_POLICY"),
+		CacheSizeEstimator: getEnvRequired("CACHE_SIZE_ESTIMATOR"),
+		CacheShards:        getEnvAsIntRequired("CACHE_SHARDS"),
+		CacheNegativeTTL:   time.Duration(getEnvAsIntRequired("CACHE_NEGATIVE_TTL_MS")) * time.Millisecond,
+		CacheNegativeMax:   getEnvAsIntRequired("CACHE_NEGATIVE_MAX_ENTRIES"),
+		CacheSnapshotDir:   getEnvRequired("CACHE_SNAPSHOT_DIR"),
+		CacheSnapshotEvery: time.Duration(getEnvAsIntRequired("CACHE_SNAPSHOT_INTERVAL_MS")) * time.Millisecond,
+		InvalidationBatch:  getEnvAsIntRequired("CACHE_INVALIDATION_BATCH_SIZE"),
+		InvalidationWait:   time.Duration(getEnvAsIntRequired("CACHE_INVALIDATION_BATCH_TIMEOUT_MS")) * time.Millisecond,
+		HTTPShutdownDelay:  time.Duration(getEnvAsIntRequired("HTTP_SHUTDOWN_DELAY_MS")) * time.Millisecond,
+		HTTPDrainTimeout:   time.Duration(getEnvAsIntRequired("HTTP_DRAIN_TIMEOUT_MS")) * time.Millisecond,
+		DBReadTimeout:      time.Duration(getEnvAsIntRequired("DB_READ_TIMEOUT_MS")) * time.Millisecond,
+		DBWriteTimeout:     time.Duration(getEnvAsIntRequired("DB_WRITE_TIMEOUT_MS")) * time.Millisecond,
+		DBStreamTimeout:    time.Duration(getEnvAsIntRequired("DB_STREAM_TIMEOUT_MS")) * time.Millisecond,
+		ConsistencyRules:   getEnvRequired("VALIDATION_CONSISTENCY_RULES"),
+		ValidationFile:     getEnvRequired("VALIDATION_RULES_FILE"),
+		ValidationReload:   time.Duration(getEnvAsIntRequired("VALIDATION_RULES_RELOAD_MS")) * time.Millisecond,
 	}
+
+	cfg.ValidationRules, err = LoadValidationRules(cfg.ValidationFile)
+	if err != nil {
+		panic("Failed to load validation rules: " + err.Error())
+	}
+
+	return cfg
 }
 
 func getEnvRequired(key string) string {
@@ -70,3 +129,15 @@ func getEnvAsInt64Required(key string) int64 {
 	}
 	return value
 }
+
+// getEnvAsListRequired splits a comma-separated value. An empty value gives
+// an empty list.
+func getEnvAsListRequired(key string) []string {
+	var list []string
+	for _, v := range strings.Split(getEnvRequired(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
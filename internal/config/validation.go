@@ -0,0 +1,55 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/goccy/go-json"
+)
+
+// ValidationRules is the content of the validation rules file.
+type ValidationRules struct {
+	Fields []FieldRule `json:"fields"`
+}
+
+// FieldRule constrains the value at a JSON path of an order. Paths into
+// items use "items[]", such as "items[].price", and apply to every item.
+type FieldRule struct {
+	Path     string `json:"path"`
+	Required bool   `json:"required,omitempty"`
+	// MaxLength is the largest length of a string, in characters as counted
+	// by a VARCHAR column.
+	MaxLength int      `json:"max_length,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+	// Format names a standard the string must follow: "iso4217" for
+	// currency codes or "bcp47" for language tags.
+	Format string `json:"format,omitempty"`
+	// Min and Max bound a number, inclusively unless MinExclusive is set.
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	MinExclusive bool     `json:"min_exclusive,omitempty"`
+}
+
+// ParseValidationRules decodes a validation rules file. Unknown keys are
+// rejected so that a misspelt rule is not silently ignored.
+func ParseValidationRules(data []byte) (*ValidationRules, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var rules ValidationRules
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("invalid validation rules: %v", err)
+	}
+	return &rules, nil
+}
+
+// LoadValidationRules reads and decodes the validation rules file at path.
+func LoadValidationRules(path string) (*ValidationRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseValidationRules(data)
+}
@@ -0,0 +1,329 @@
+package consumer
+
+import (
+	"L0/internal/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	HeaderError             = "x-error"
+	HeaderAttempts          = "x-attempts"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	// HeaderValidationErrors carries the JSON encoded validation errors of
+	// a message rejected as invalid.
+	HeaderValidationErrors = "x-validation-errors"
+)
+
+// MessageReader is the subset of *kafka.Reader used by the consumer.
+// Offsets are committed explicitly, so the reader must not auto-commit.
+type MessageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// MessageWriter is the subset of *kafka.Writer used to publish dead letters.
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Processor handles a single raw order message.
+type Processor interface {
+	ProcessOrderMessage(ctx context.Context, message []byte) error
+}
+
+// BatchProcessor stores several raw order messages at once. The returned
+// errors are aligned with messages.
+type BatchProcessor interface {
+	ProcessOrderMessages(ctx context.Context, messages [][]byte) []error
+}
+
+type Logger interface {
+	Log(level, service, message string)
+}
+
+// RetryPolicy bounds how many times a message is processed before it is
+// sent to the dead-letter topic.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// IsRetryable decides whether a processing error is transient.
+	IsRetryable func(error) bool
+	// IsAlreadyApplied reports errors meaning the message has already been
+	// stored, e.g. by a delivery whose offset was never committed. Such
+	// messages are committed without being dead-lettered.
+	IsAlreadyApplied func(error) bool
+	// ValidationErrors returns the JSON encoded validation errors a
+	// processing error carries, or nil. They are attached to the dead
+	// letter.
+	ValidationErrors func(error) []byte
+}
+
+// minRedeliveryDelay is the least time handleUntilDone waits before
+// handling a message again, so that a zero backoff does not make it spin
+// while the dead-letter topic is unreachable.
+const minRedeliveryDelay = 100 * time.Millisecond
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff * time.Duration(1<<uint(attempt-1))
+	if p.MaxBackoff > 0 && (delay > p.MaxBackoff || delay <= 0) {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+type Consumer struct {
+	reader    MessageReader
+	dlq       MessageWriter
+	processor Processor
+	policy    RetryPolicy
+	logger    Logger
+
+	batch        BatchProcessor
+	batchSize    int
+	batchTimeout time.Duration
+}
+
+func New(reader MessageReader, dlq MessageWriter, processor Processor, policy RetryPolicy, logger Logger) *Consumer {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.IsRetryable == nil {
+		policy.IsRetryable = func(error) bool { return false }
+	}
+	if policy.IsAlreadyApplied == nil {
+		policy.IsAlreadyApplied = func(error) bool { return false }
+	}
+	if policy.ValidationErrors == nil {
+		policy.ValidationErrors = func(error) []byte { return nil }
+	}
+
+	return &Consumer{
+		reader:    reader,
+		dlq:       dlq,
+		processor: processor,
+		policy:    policy,
+		logger:    logger,
+	}
+}
+
+// WithBatching switches the consumer to batch mode: it gathers up to size
+// messages, waiting at most timeout after the first one, and stores them
+// with a single call to processor. Messages that fail in a batch go through
+// the regular retry and dead-letter path one by one. A size below 2 leaves
+// batching disabled.
+func (c *Consumer) WithBatching(processor BatchProcessor, size int, timeout time.Duration) *Consumer {
+	if size < 2 {
+		return c
+	}
+
+	c.batch = processor
+	c.batchSize = size
+	c.batchTimeout = timeout
+	return c
+}
+
+// Run fetches messages until ctx is cancelled. The offset of a message is
+// committed only after it has been stored or dead-lettered, so a crash in
+// between leads to redelivery instead of a lost order.
+func (c *Consumer) Run(ctx context.Context) {
+	if c.batch != nil {
+		c.runBatches(ctx)
+		return
+	}
+
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Log("ERROR", "consumer", "Error fetching message: "+err.Error())
+			log.Println("Error fetching message:", err)
+			continue
+		}
+
+		if !c.handleUntilDone(ctx, msg) {
+			return
+		}
+
+		if err := c.reader.CommitMessages(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Log("ERROR", "consumer", "Error committing offset: "+err.Error())
+			log.Println("Error committing offset:", err)
+		}
+	}
+}
+
+func (c *Consumer) runBatches(ctx context.Context) {
+	for {
+		batch := c.fetchBatch(ctx)
+		if len(batch) == 0 {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		values := make([][]byte, len(batch))
+		for i, msg := range batch {
+			values[i] = msg.Value
+		}
+
+		errs := c.batch.ProcessOrderMessages(ctx, values)
+		for i, msg := range batch {
+			if errs[i] == nil || c.policy.IsAlreadyApplied(errs[i]) {
+				continue
+			}
+			if !c.handleUntilDone(ctx, msg) {
+				return
+			}
+		}
+
+		if err := c.reader.CommitMessages(ctx, batch...); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Log("ERROR", "consumer", "Error committing offsets: "+err.Error())
+			log.Println("Error committing offsets:", err)
+		}
+	}
+}
+
+// fetchBatch blocks until the first message arrives and then collects more
+// until the batch is full or batchTimeout has passed.
+func (c *Consumer) fetchBatch(ctx context.Context) []kafka.Message {
+	msg, err := c.reader.FetchMessage(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.logger.Log("ERROR", "consumer", "Error fetching message: "+err.Error())
+			log.Println("Error fetching message:", err)
+		}
+		return nil
+	}
+
+	batch := make([]kafka.Message, 1, c.batchSize)
+	batch[0] = msg
+
+	fetchCtx, cancel := context.WithTimeout(ctx, c.batchTimeout)
+	defer cancel()
+
+	for len(batch) < c.batchSize {
+		msg, err := c.reader.FetchMessage(fetchCtx)
+		if err != nil {
+			if fetchCtx.Err() == nil {
+				c.logger.Log("ERROR", "consumer", "Error fetching message: "+err.Error())
+				log.Println("Error fetching message:", err)
+			}
+			break
+		}
+		batch = append(batch, msg)
+	}
+
+	return batch
+}
+
+// handleUntilDone keeps handling msg until it is stored or dead-lettered.
+// Committing a later offset would implicitly commit msg as well, so the
+// consumer must not move past it. It returns false if ctx is cancelled first.
+func (c *Consumer) handleUntilDone(ctx context.Context, msg kafka.Message) bool {
+	for {
+		err := c.Handle(ctx, msg)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+
+		c.logger.Log("ERROR", "consumer", "Error handling message: "+err.Error())
+		log.Println("Error handling message:", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(max(c.policy.backoff(c.policy.MaxAttempts), minRedeliveryDelay)):
+		}
+	}
+}
+
+// Handle processes msg with retries. A message that fails for good is
+// published to the dead-letter topic.
+func (c *Consumer) Handle(ctx context.Context, msg kafka.Message) error {
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = c.processor.ProcessOrderMessage(ctx, msg.Value)
+		if err == nil {
+			log.Printf("Order %s processed successfully", string(msg.Key))
+			return nil
+		}
+		if ctx.Err() != nil {
+			// Processing was cut short, the message is not at fault.
+			return ctx.Err()
+		}
+		if c.policy.IsAlreadyApplied(err) {
+			log.Printf("Order %s was already stored, skipping", string(msg.Key))
+			return nil
+		}
+
+		if !c.policy.IsRetryable(err) || attempt >= c.policy.MaxAttempts {
+			break
+		}
+
+		metrics.KafkaRetries.Inc()
+		delay := c.policy.backoff(attempt)
+		c.logger.Log("WARN", "consumer", fmt.Sprintf("Attempt %d for message at offset %d failed: %v. Retrying in %v",
+			attempt, msg.Offset, err, delay))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	c.logger.Log("ERROR", "consumer", fmt.Sprintf("Message at offset %d failed after %d attempt(s): %v",
+		msg.Offset, attempt, err))
+
+	return c.deadLetter(ctx, msg, err, attempt)
+}
+
+func (c *Consumer) deadLetter(ctx context.Context, msg kafka.Message, cause error, attempts int) error {
+	if c.dlq == nil {
+		return fmt.Errorf("no dead-letter writer configured: %w", cause)
+	}
+
+	dead := kafka.Message{
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: append(append([]kafka.Header(nil), msg.Headers...),
+			kafka.Header{Key: HeaderError, Value: []byte(cause.Error())},
+			kafka.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+			kafka.Header{Key: HeaderOriginalTopic, Value: []byte(msg.Topic)},
+			kafka.Header{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+			kafka.Header{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		),
+	}
+	if violations := c.policy.ValidationErrors(cause); violations != nil {
+		dead.Headers = append(dead.Headers, kafka.Header{Key: HeaderValidationErrors, Value: violations})
+	}
+
+	if err := c.dlq.WriteMessages(ctx, dead); err != nil {
+		return errors.Join(fmt.Errorf("error publishing to dead-letter topic: %v", err), cause)
+	}
+
+	metrics.KafkaDeadLettered.Inc()
+	return nil
+}
@@ -0,0 +1,496 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTransient = errors.New("transient")
+
+// fakeReader mimics a consumer group member of a single partition: fetching
+// starts at the last committed offset, and uncommitted messages are
+// redelivered to the next reader of the same partition.
+type fakeReader struct {
+	mu        sync.Mutex
+	messages  []kafka.Message
+	next      int
+	committed int
+}
+
+func newFakeReader(msgs ...kafka.Message) *fakeReader {
+	for i := range msgs {
+		msgs[i].Offset = int64(i)
+	}
+	return &fakeReader{messages: msgs}
+}
+
+// restart simulates a crash: uncommitted progress is lost.
+func (r *fakeReader) restart() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.next = r.committed
+}
+
+func (r *fakeReader) committedOffset() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.committed
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if r.next < len(r.messages) {
+		msg := r.messages[r.next]
+		r.next++
+		r.mu.Unlock()
+		return msg, nil
+	}
+	r.mu.Unlock()
+
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		if int(msg.Offset)+1 > r.committed {
+			r.committed = int(msg.Offset) + 1
+		}
+	}
+	return nil
+}
+
+type fakeWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	err      error
+}
+
+func (w *fakeWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) written() []kafka.Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]kafka.Message(nil), w.messages...)
+}
+
+type fakeProcessor struct {
+	mu    sync.Mutex
+	calls int
+	errs  []error
+	// err is returned once errs is exhausted.
+	err error
+}
+
+func (p *fakeProcessor) ProcessOrderMessage(context.Context, []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if len(p.errs) == 0 {
+		return p.err
+	}
+	err := p.errs[0]
+	p.errs = p.errs[1:]
+	return err
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(string, string, string) {}
+
+func testPolicy(maxAttempts int) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		IsRetryable:    func(err error) bool { return errors.Is(err, errTransient) },
+	}
+}
+
+func header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func testMessage() kafka.Message {
+	return kafka.Message{
+		Topic:     "orders",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("test123"),
+		Value:     []byte(`{"order_uid":"test123"}`),
+	}
+}
+
+func TestConsumer_Handle_Success(t *testing.T) {
+	processor := &fakeProcessor{}
+	dlq := &fakeWriter{}
+	c := New(nil, dlq, processor, testPolicy(3), nopLogger{})
+
+	err := c.Handle(context.Background(), testMessage())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, processor.calls)
+	assert.Empty(t, dlq.written())
+}
+
+func TestConsumer_Handle_RetriesTransientErrors(t *testing.T) {
+	processor := &fakeProcessor{errs: []error{errTransient, errTransient}}
+	dlq := &fakeWriter{}
+	c := New(nil, dlq, processor, testPolicy(3), nopLogger{})
+
+	err := c.Handle(context.Background(), testMessage())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, processor.calls)
+	assert.Empty(t, dlq.written())
+}
+
+func TestConsumer_Handle_DeadLettersAfterMaxAttempts(t *testing.T) {
+	processor := &fakeProcessor{errs: []error{errTransient, errTransient, errTransient}}
+	dlq := &fakeWriter{}
+	c := New(nil, dlq, processor, testPolicy(3), nopLogger{})
+
+	msg := testMessage()
+	err := c.Handle(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, processor.calls)
+
+	written := dlq.written()
+	require.Len(t, written, 1)
+	assert.Equal(t, msg.Value, written[0].Value)
+	assert.Equal(t, msg.Key, written[0].Key)
+	assert.Equal(t, "transient", header(written[0], HeaderError))
+	assert.Equal(t, "3", header(written[0], HeaderAttempts))
+	assert.Equal(t, "orders", header(written[0], HeaderOriginalTopic))
+	assert.Equal(t, "2", header(written[0], HeaderOriginalPartition))
+	assert.Equal(t, "42", header(written[0], HeaderOriginalOffset))
+}
+
+func TestConsumer_Handle_PermanentErrorIsNotRetried(t *testing.T) {
+	processor := &fakeProcessor{errs: []error{errors.New("validation error: order UID is required")}}
+	dlq := &fakeWriter{}
+	c := New(nil, dlq, processor, testPolicy(5), nopLogger{})
+
+	err := c.Handle(context.Background(), testMessage())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, processor.calls)
+
+	written := dlq.written()
+	require.Len(t, written, 1)
+	assert.Equal(t, "1", header(written[0], HeaderAttempts))
+	assert.Contains(t, header(written[0], HeaderError), "validation error")
+}
+
+func TestConsumer_Handle_DeadLetterCarriesValidationErrors(t *testing.T) {
+	invalid := errors.New("validation error: items[0].price: must be positive")
+	processor := &fakeProcessor{errs: []error{invalid, errors.New("bad message")}}
+	dlq := &fakeWriter{}
+	policy := testPolicy(1)
+	policy.ValidationErrors = func(err error) []byte {
+		if err == invalid {
+			return []byte(`[{"field":"items[0].price","code":"not_positive","message":"must be positive"}]`)
+		}
+		return nil
+	}
+	c := New(nil, dlq, processor, policy, nopLogger{})
+
+	require.NoError(t, c.Handle(context.Background(), testMessage()))
+	require.NoError(t, c.Handle(context.Background(), testMessage()))
+
+	written := dlq.written()
+	require.Len(t, written, 2)
+	assert.JSONEq(t, `[{"field":"items[0].price","code":"not_positive","message":"must be positive"}]`,
+		header(written[0], HeaderValidationErrors))
+	for _, h := range written[1].Headers {
+		assert.NotEqual(t, HeaderValidationErrors, h.Key)
+	}
+}
+
+func TestConsumer_Handle_CancelledIsNotDeadLettered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A storage call cut short by shutdown fails like any other.
+	processor := &fakeProcessor{err: errTransient}
+	dlq := &fakeWriter{}
+	c := New(nil, dlq, processor, testPolicy(1), nopLogger{})
+
+	err := c.Handle(ctx, testMessage())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, dlq.written())
+}
+
+func TestConsumer_Handle_DeadLetterWriteFails(t *testing.T) {
+	processor := &fakeProcessor{errs: []error{errors.New("bad message")}}
+	dlq := &fakeWriter{err: errors.New("broker unavailable")}
+	c := New(nil, dlq, processor, testPolicy(1), nopLogger{})
+
+	err := c.Handle(context.Background(), testMessage())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "dead-letter")
+}
+
+// fakeStore processes messages like OrderService: it stores each message
+// once and reports duplicates with errDuplicate.
+type fakeStore struct {
+	mu     sync.Mutex
+	orders map[string]int
+	// afterSave is called once the message is stored.
+	afterSave func()
+}
+
+var errDuplicate = errors.New("order already exists")
+
+func (s *fakeStore) ProcessOrderMessage(ctx context.Context, message []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.orders == nil {
+		s.orders = make(map[string]int)
+	}
+	if _, exists := s.orders[string(message)]; exists {
+		return errDuplicate
+	}
+	s.orders[string(message)]++
+	if s.afterSave != nil {
+		s.afterSave()
+	}
+	return nil
+}
+
+func (s *fakeStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.orders)
+}
+
+func runUntil(t *testing.T, c *Consumer, ctx context.Context, cancel context.CancelFunc, cond func() bool) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, cond, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestConsumer_Run(t *testing.T) {
+	reader := newFakeReader(testMessage(), testMessage())
+	processor := &fakeProcessor{errs: []error{nil, errors.New("bad message")}}
+	dlq := &fakeWriter{}
+	c := New(reader, dlq, processor, testPolicy(3), nopLogger{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runUntil(t, c, ctx, cancel, func() bool { return reader.committedOffset() == 2 })
+
+	assert.Equal(t, 2, processor.calls)
+	assert.Len(t, dlq.written(), 1)
+}
+
+func TestConsumer_Run_RedeliversAfterCrashBeforeCommit(t *testing.T) {
+	reader := newFakeReader(
+		kafka.Message{Key: []byte("order-1"), Value: []byte("order-1")},
+		kafka.Message{Key: []byte("order-2"), Value: []byte("order-2")},
+	)
+	policy := testPolicy(3)
+	policy.IsAlreadyApplied = func(err error) bool { return errors.Is(err, errDuplicate) }
+
+	// The process dies right after the first order is stored, before its
+	// offset is committed.
+	ctx, crash := context.WithCancel(context.Background())
+	store := &fakeStore{afterSave: crash}
+	dlq := &fakeWriter{}
+
+	c := New(reader, dlq, store, policy, nopLogger{})
+	c.Run(ctx)
+
+	assert.Equal(t, 1, store.count())
+	assert.Equal(t, 0, reader.committedOffset())
+
+	// After a restart the message is delivered again; the duplicate is
+	// committed without reaching the dead-letter topic.
+	reader.restart()
+	store.afterSave = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	c = New(reader, dlq, store, policy, nopLogger{})
+	runUntil(t, c, ctx, cancel, func() bool { return reader.committedOffset() == 2 })
+
+	assert.Equal(t, 2, store.count())
+	assert.Empty(t, dlq.written())
+}
+
+func TestConsumer_Run_RedeliversAfterCrashBeforeSave(t *testing.T) {
+	reader := newFakeReader(kafka.Message{Key: []byte("order-1"), Value: []byte("order-1")})
+
+	// Every attempt fails with a transient error until the process dies
+	// while waiting to retry.
+	ctx, crash := context.WithCancel(context.Background())
+	processor := &fakeProcessor{errs: []error{errTransient, errTransient}}
+	policy := testPolicy(3)
+	policy.InitialBackoff = time.Hour
+	policy.MaxBackoff = time.Hour
+
+	c := New(reader, &fakeWriter{}, processor, policy, nopLogger{})
+	go func() {
+		assert.Eventually(t, func() bool {
+			processor.mu.Lock()
+			defer processor.mu.Unlock()
+			return processor.calls == 1
+		}, time.Second, time.Millisecond)
+		crash()
+	}()
+	c.Run(ctx)
+
+	assert.Equal(t, 0, reader.committedOffset())
+
+	reader.restart()
+	store := &fakeStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+	c = New(reader, &fakeWriter{}, store, testPolicy(3), nopLogger{})
+	runUntil(t, c, ctx, cancel, func() bool { return reader.committedOffset() == 1 })
+
+	assert.Equal(t, 1, store.count())
+}
+
+func TestConsumer_Run_DoesNotCommitUntilDeadLettered(t *testing.T) {
+	reader := newFakeReader(testMessage(), testMessage())
+	processor := &fakeProcessor{err: errors.New("bad message")}
+	dlq := &fakeWriter{err: errors.New("broker unavailable")}
+	c := New(reader, dlq, processor, testPolicy(1), nopLogger{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		processor.mu.Lock()
+		defer processor.mu.Unlock()
+		return processor.calls >= 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 0, reader.committedOffset())
+
+	// Once the dead-letter topic is reachable again the message is
+	// published and its offset committed.
+	dlq.mu.Lock()
+	dlq.err = nil
+	dlq.mu.Unlock()
+
+	assert.Eventually(t, func() bool { return reader.committedOffset() == 2 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Len(t, dlq.written(), 2)
+}
+
+func TestConsumer_Run_WaitsBeforeRedelivery(t *testing.T) {
+	reader := newFakeReader(testMessage())
+	processor := &fakeProcessor{err: errors.New("bad message")}
+	dlq := &fakeWriter{err: errors.New("broker unavailable")}
+	policy := testPolicy(1)
+	policy.InitialBackoff = 0
+	policy.MaxBackoff = 0
+	c := New(reader, dlq, processor, policy, nopLogger{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*minRedeliveryDelay+minRedeliveryDelay/2)
+	defer cancel()
+	c.Run(ctx)
+
+	// Without a backoff the message is still handled at most once per
+	// minRedeliveryDelay.
+	processor.mu.Lock()
+	defer processor.mu.Unlock()
+	assert.LessOrEqual(t, processor.calls, 3)
+	assert.Equal(t, 0, reader.committedOffset())
+}
+
+type fakeBatchProcessor struct {
+	mu      sync.Mutex
+	batches [][][]byte
+	// fail lists message values the batch rejects.
+	fail map[string]error
+}
+
+func (p *fakeBatchProcessor) ProcessOrderMessages(ctx context.Context, messages [][]byte) []error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = append(p.batches, messages)
+
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		errs[i] = p.fail[string(message)]
+	}
+	return errs
+}
+
+func (p *fakeBatchProcessor) batchSizes() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sizes := make([]int, len(p.batches))
+	for i, batch := range p.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func TestConsumer_Run_Batches(t *testing.T) {
+	var msgs []kafka.Message
+	for _, v := range []string{"a", "b", "bad", "c", "d"} {
+		msgs = append(msgs, kafka.Message{Key: []byte(v), Value: []byte(v)})
+	}
+	reader := newFakeReader(msgs...)
+
+	batch := &fakeBatchProcessor{fail: map[string]error{"bad": errors.New("validation error")}}
+	// The single-message path sees the same permanent failure.
+	processor := &fakeProcessor{err: errors.New("validation error")}
+	dlq := &fakeWriter{}
+
+	c := New(reader, dlq, processor, testPolicy(3), nopLogger{}).
+		WithBatching(batch, 2, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runUntil(t, c, ctx, cancel, func() bool { return reader.committedOffset() == 5 })
+
+	assert.Equal(t, []int{2, 2, 1}, batch.batchSizes())
+	assert.Equal(t, 1, processor.calls)
+
+	written := dlq.written()
+	require.Len(t, written, 1)
+	assert.Equal(t, "bad", string(written[0].Value))
+}
+
+func TestConsumer_WithBatching_Disabled(t *testing.T) {
+	c := New(nil, nil, &fakeProcessor{}, testPolicy(1), nopLogger{}).
+		WithBatching(&fakeBatchProcessor{}, 1, time.Second)
+
+	assert.Nil(t, c.batch)
+}
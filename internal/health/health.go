@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Probe reports liveness and readiness separately. A live process may still
+// be warming up, so it is not ready to take traffic until SetReady(true).
+// Once it starts draining for shutdown, it is never ready again.
+type Probe struct {
+	ready    atomic.Bool
+	draining atomic.Bool
+	pinger   Pinger
+}
+
+func NewProbe(pinger Pinger) *Probe {
+	return &Probe{pinger: pinger}
+}
+
+func (p *Probe) SetReady(ready bool) {
+	p.ready.Store(ready)
+}
+
+// Drain marks the process as shutting down.
+func (p *Probe) Drain() {
+	p.draining.Store(true)
+}
+
+func (p *Probe) Ready() bool {
+	return p.ready.Load() && !p.draining.Load()
+}
+
+// LiveHandler answers OK as long as the process can serve requests.
+func (p *Probe) LiveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// ReadyHandler answers OK once the process is ready and the database is
+// reachable.
+func (p *Probe) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.Ready() {
+			http.Error(w, "Not ready", http.StatusServiceUnavailable)
+			return
+		}
+		if err := p.pinger.Ping(r.Context()); err != nil {
+			http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
@@ -0,0 +1,54 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePinger struct {
+	err error
+}
+
+func (p *fakePinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func serve(handler http.HandlerFunc) int {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec.Code
+}
+
+func TestProbe_Ready(t *testing.T) {
+	pinger := &fakePinger{}
+	probe := NewProbe(pinger)
+
+	// Live while warming up, but not ready
+	assert.Equal(t, http.StatusOK, serve(probe.LiveHandler()))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(probe.ReadyHandler()))
+
+	probe.SetReady(true)
+	assert.Equal(t, http.StatusOK, serve(probe.ReadyHandler()))
+
+	pinger.err = errors.New("connection refused")
+	assert.Equal(t, http.StatusServiceUnavailable, serve(probe.ReadyHandler()))
+	assert.Equal(t, http.StatusOK, serve(probe.LiveHandler()))
+}
+
+func TestProbe_Drain(t *testing.T) {
+	probe := NewProbe(&fakePinger{})
+	probe.SetReady(true)
+
+	probe.Drain()
+	assert.Equal(t, http.StatusServiceUnavailable, serve(probe.ReadyHandler()))
+	assert.Equal(t, http.StatusOK, serve(probe.LiveHandler()))
+
+	// A warm-up finishing late does not make a draining process ready
+	probe.SetReady(true)
+	assert.False(t, probe.Ready())
+}
@@ -0,0 +1,267 @@
+package invalidation
+
+import (
+	"L0/internal/metrics"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres notification channel order changes are published
+// on, by the trigger on the orders table and by Publisher.
+const Channel = "order_changes"
+
+// Operations carried by events. The first three are the trigger operations
+// on the orders table.
+const (
+	OpInsert = "INSERT"
+	OpUpdate = "UPDATE"
+	OpDelete = "DELETE"
+	// OpClear asks every instance to clear its cache.
+	OpClear = "CLEAR"
+)
+
+const (
+	minReconnectInterval = 10 * time.Second
+	maxReconnectInterval = time.Minute
+	// pingInterval is how often the connection is checked while idle, as a
+	// dead connection is otherwise noticed only when the server sends
+	// something.
+	pingInterval = 90 * time.Second
+	// resyncMargin widens the window of orders reconciled after a lost
+	// connection. It covers clock skew between the application and the
+	// database and the time between writing a row, which sets its
+	// updated_at, and committing it. That time is bounded by the write
+	// timeout of the repository, which must stay below the margin.
+	resyncMargin = time.Minute
+)
+
+// Event is the payload of a notification.
+type Event struct {
+	Op       string `json:"op"`
+	OrderUID string `json:"order_uid,omitempty"`
+	Version  int    `json:"version,omitempty"`
+}
+
+// Handler applies events to a cache. The versions maps hold the order
+// version each event was published for.
+type Handler interface {
+	// CacheOrders loads and caches created orders.
+	CacheOrders(ctx context.Context, versions map[string]int) error
+	// RefreshOrders reloads updated orders that are cached.
+	RefreshOrders(ctx context.Context, versions map[string]int) error
+	// EvictOrders drops deleted orders.
+	EvictOrders(uids []string)
+	ClearCache()
+	// ReconcileCache refreshes the cache with the orders changed since a
+	// time, when notifications may have been lost.
+	ReconcileCache(ctx context.Context, since time.Time) error
+}
+
+type Logger interface {
+	Log(level, service, message string)
+}
+
+// Listener applies the order changes made by any process to the local
+// cache.
+type Listener struct {
+	handler Handler
+	logger  Logger
+	service string
+
+	batchSize    int
+	batchTimeout time.Duration
+
+	listener *pq.Listener
+
+	mu             sync.Mutex
+	disconnectedAt time.Time
+}
+
+// NewListener creates a listener connecting to the database at dsn. service
+// names the process in log entries.
+func NewListener(dsn string, handler Handler, logger Logger, service string) *Listener {
+	l := &Listener{
+		handler:   handler,
+		logger:    logger,
+		service:   service,
+		batchSize: 1,
+	}
+	l.listener = pq.NewListener(dsn, minReconnectInterval, maxReconnectInterval, l.onConnectionEvent)
+	return l
+}
+
+// WithBatching makes the listener gather up to size events, waiting at
+// most timeout after the first one, and apply them together. Events of the
+// same order are merged, so a burst of changes costs one database load.
+func (l *Listener) WithBatching(size int, timeout time.Duration) *Listener {
+	if size > 1 {
+		l.batchSize = size
+		l.batchTimeout = timeout
+	}
+	return l
+}
+
+// Run applies events until ctx is cancelled and then closes the listener.
+// When the connection is lost, the cache is reconciled with the orders
+// changed in the meantime once it is back.
+func (l *Listener) Run(ctx context.Context) error {
+	if err := l.listener.Listen(Channel); err != nil {
+		l.listener.Close()
+		return fmt.Errorf("error listening for order changes: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.listener.Ping()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	l.run(ctx, l.listener.Notify)
+	return l.listener.Close()
+}
+
+func (l *Listener) onConnectionEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		l.mu.Lock()
+		if l.disconnectedAt.IsZero() {
+			l.disconnectedAt = time.Now()
+		}
+		l.mu.Unlock()
+		l.logError("Lost connection for cache invalidation", err)
+	case pq.ListenerEventConnectionAttemptFailed:
+		l.logError("Failed to reconnect for cache invalidation", err)
+	}
+}
+
+// run applies the events received on notifications. A nil notification
+// means the connection was re-established.
+func (l *Listener) run(ctx context.Context, notifications <-chan *pq.Notification) {
+	pending := make(map[string]Event)
+	var timeout <-chan time.Time
+
+	flush := func() {
+		l.apply(ctx, pending)
+		pending = make(map[string]Event)
+		timeout = nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			flush()
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if n == nil {
+				flush()
+				l.resync(ctx)
+				continue
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				l.logError("Invalid cache invalidation event", err)
+				continue
+			}
+			metrics.CacheInvalidations.WithLabelValues(event.Op).Inc()
+
+			if event.Op == OpClear {
+				pending = make(map[string]Event)
+				timeout = nil
+				l.handler.ClearCache()
+				continue
+			}
+
+			pending[event.OrderUID] = merge(pending[event.OrderUID], event)
+			if len(pending) >= l.batchSize {
+				flush()
+			} else if timeout == nil {
+				timeout = time.After(l.batchTimeout)
+			}
+		}
+	}
+}
+
+// merge folds event into the earlier pending event of the same order. An
+// order created and then updated within a batch is still new to the cache.
+func merge(pending, event Event) Event {
+	if pending.Op == OpInsert && event.Op == OpUpdate {
+		event.Op = OpInsert
+	}
+	return event
+}
+
+func (l *Listener) apply(ctx context.Context, pending map[string]Event) {
+	created := make(map[string]int)
+	updated := make(map[string]int)
+	var deleted []string
+
+	for uid, event := range pending {
+		switch event.Op {
+		case OpInsert:
+			created[uid] = event.Version
+		case OpUpdate:
+			updated[uid] = event.Version
+		case OpDelete:
+			deleted = append(deleted, uid)
+		}
+	}
+
+	if len(deleted) > 0 {
+		l.handler.EvictOrders(deleted)
+	}
+	if len(created) > 0 {
+		if err := l.handler.CacheOrders(ctx, created); err != nil {
+			l.logError("Failed to cache created orders", err)
+		}
+	}
+	if len(updated) > 0 {
+		if err := l.handler.RefreshOrders(ctx, updated); err != nil {
+			l.logError("Failed to refresh updated orders", err)
+		}
+	}
+}
+
+// resync catches up with the changes made while the connection was down.
+func (l *Listener) resync(ctx context.Context) {
+	l.mu.Lock()
+	since := l.disconnectedAt
+	l.disconnectedAt = time.Time{}
+	l.mu.Unlock()
+
+	if since.IsZero() {
+		// Nothing tells how much was missed.
+		l.handler.ClearCache()
+		return
+	}
+
+	if err := l.handler.ReconcileCache(ctx, since.Add(-resyncMargin)); err != nil {
+		l.logError("Failed to reconcile cache after reconnecting", err)
+	}
+}
+
+func (l *Listener) logError(message string, err error) {
+	if err != nil {
+		message += ": " + err.Error()
+	}
+	l.logger.Log("ERROR", l.service, message)
+	log.Println(message)
+}
@@ -0,0 +1,165 @@
+package invalidation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type call struct {
+	method   string
+	versions map[string]int
+	uids     []string
+	since    time.Time
+}
+
+// fakeHandler passes every call it receives to calls.
+type fakeHandler struct {
+	calls chan call
+}
+
+func (h *fakeHandler) CacheOrders(ctx context.Context, versions map[string]int) error {
+	h.calls <- call{method: "CacheOrders", versions: versions}
+	return nil
+}
+
+func (h *fakeHandler) RefreshOrders(ctx context.Context, versions map[string]int) error {
+	h.calls <- call{method: "RefreshOrders", versions: versions}
+	return nil
+}
+
+func (h *fakeHandler) EvictOrders(uids []string) {
+	h.calls <- call{method: "EvictOrders", uids: uids}
+}
+
+func (h *fakeHandler) ClearCache() {
+	h.calls <- call{method: "ClearCache"}
+}
+
+func (h *fakeHandler) ReconcileCache(ctx context.Context, since time.Time) error {
+	h.calls <- call{method: "ReconcileCache", since: since}
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(level, service, message string) {}
+
+// startListener runs a listener without a database connection on the
+// returned notification channel.
+func startListener(t *testing.T, batchSize int, batchTimeout time.Duration) (*Listener, *fakeHandler, chan *pq.Notification) {
+	handler := &fakeHandler{calls: make(chan call, 10)}
+	l := (&Listener{handler: handler, logger: nopLogger{}, service: "test", batchSize: 1}).
+		WithBatching(batchSize, batchTimeout)
+	notifications := make(chan *pq.Notification)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.run(ctx, notifications)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return l, handler, notifications
+}
+
+func notification(t *testing.T, event Event) *pq.Notification {
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+	return &pq.Notification{Channel: Channel, Extra: string(payload)}
+}
+
+func nextCall(t *testing.T, handler *fakeHandler) call {
+	select {
+	case c := <-handler.calls:
+		return c
+	case <-time.After(time.Second):
+		t.Fatal("handler was not called")
+		return call{}
+	}
+}
+
+func TestListener_AppliesEvents(t *testing.T) {
+	_, handler, notifications := startListener(t, 1, 0)
+
+	notifications <- notification(t, Event{Op: OpInsert, OrderUID: "a", Version: 1})
+	assert.Equal(t, call{method: "CacheOrders", versions: map[string]int{"a": 1}}, nextCall(t, handler))
+
+	notifications <- notification(t, Event{Op: OpUpdate, OrderUID: "a", Version: 2})
+	assert.Equal(t, call{method: "RefreshOrders", versions: map[string]int{"a": 2}}, nextCall(t, handler))
+
+	notifications <- notification(t, Event{Op: OpDelete, OrderUID: "a", Version: 2})
+	assert.Equal(t, call{method: "EvictOrders", uids: []string{"a"}}, nextCall(t, handler))
+
+	notifications <- notification(t, Event{Op: OpClear})
+	assert.Equal(t, call{method: "ClearCache"}, nextCall(t, handler))
+}
+
+func TestListener_BatchesEvents(t *testing.T) {
+	_, handler, notifications := startListener(t, 10, 50*time.Millisecond)
+
+	notifications <- notification(t, Event{Op: OpInsert, OrderUID: "a", Version: 1})
+	notifications <- notification(t, Event{Op: OpUpdate, OrderUID: "a", Version: 2})
+	notifications <- notification(t, Event{Op: OpUpdate, OrderUID: "b", Version: 3})
+	notifications <- notification(t, Event{Op: OpUpdate, OrderUID: "c", Version: 4})
+	notifications <- notification(t, Event{Op: OpDelete, OrderUID: "c", Version: 4})
+
+	// Deletes go first, so that a reload cannot bring an order back.
+	assert.Equal(t, call{method: "EvictOrders", uids: []string{"c"}}, nextCall(t, handler))
+	assert.Equal(t, call{method: "CacheOrders", versions: map[string]int{"a": 2}}, nextCall(t, handler))
+	assert.Equal(t, call{method: "RefreshOrders", versions: map[string]int{"b": 3}}, nextCall(t, handler))
+}
+
+func TestListener_ClearDropsPendingEvents(t *testing.T) {
+	_, handler, notifications := startListener(t, 10, time.Hour)
+
+	notifications <- notification(t, Event{Op: OpUpdate, OrderUID: "a", Version: 2})
+	notifications <- notification(t, Event{Op: OpClear})
+
+	assert.Equal(t, call{method: "ClearCache"}, nextCall(t, handler))
+	assert.Empty(t, handler.calls)
+}
+
+func TestListener_IgnoresInvalidEvents(t *testing.T) {
+	_, handler, notifications := startListener(t, 1, 0)
+
+	notifications <- &pq.Notification{Channel: Channel, Extra: "not json"}
+	notifications <- notification(t, Event{Op: OpUpdate, OrderUID: "a", Version: 2})
+
+	assert.Equal(t, call{method: "RefreshOrders", versions: map[string]int{"a": 2}}, nextCall(t, handler))
+}
+
+func TestListener_ReconcilesAfterReconnect(t *testing.T) {
+	l, handler, notifications := startListener(t, 10, time.Hour)
+
+	notifications <- notification(t, Event{Op: OpUpdate, OrderUID: "a", Version: 2})
+	before := time.Now()
+	l.onConnectionEvent(pq.ListenerEventDisconnected, errors.New("connection reset"))
+	l.onConnectionEvent(pq.ListenerEventReconnected, nil)
+	notifications <- nil
+
+	// Events received before the connection was lost are applied first.
+	assert.Equal(t, "RefreshOrders", nextCall(t, handler).method)
+
+	c := nextCall(t, handler)
+	assert.Equal(t, "ReconcileCache", c.method)
+	assert.WithinDuration(t, before.Add(-resyncMargin), c.since, time.Second)
+}
+
+func TestListener_ClearsWhenMissedChangesAreUnknown(t *testing.T) {
+	_, handler, notifications := startListener(t, 1, 0)
+
+	notifications <- nil
+
+	assert.Equal(t, call{method: "ClearCache"}, nextCall(t, handler))
+}
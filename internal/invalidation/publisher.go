@@ -0,0 +1,36 @@
+package invalidation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/goccy/go-json"
+)
+
+// Publisher sends events to every listener, including the one of its own
+// process.
+type Publisher struct {
+	db *sql.DB
+}
+
+func NewPublisher(db *sql.DB) *Publisher {
+	return &Publisher{db: db}
+}
+
+func (p *Publisher) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding invalidation event: %v", err)
+	}
+
+	if _, err := p.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", Channel, string(payload)); err != nil {
+		return fmt.Errorf("error publishing invalidation event: %v", err)
+	}
+	return nil
+}
+
+// Clear asks every instance to clear its cache.
+func (p *Publisher) Clear(ctx context.Context) error {
+	return p.Publish(ctx, Event{Op: OpClear})
+}
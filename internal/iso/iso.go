@@ -0,0 +1,133 @@
+// Package iso checks currency codes against ISO 4217 and language tags
+// against BCP 47, using tables embedded in the binary.
+package iso
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"strconv"
+	"strings"
+)
+
+var (
+	//go:embed currencies.csv
+	currenciesCSV []byte
+
+	//go:embed languages.txt
+	languagesTxt []byte
+)
+
+var (
+	// currencies maps active ISO 4217 codes to the number of digits after
+	// the decimal separator of their minor unit.
+	currencies = parseCurrencies(currenciesCSV)
+	languages  = parseLanguages(languagesTxt)
+)
+
+func parseCurrencies(data []byte) map[string]int {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		panic("invalid currency table: " + err.Error())
+	}
+
+	table := make(map[string]int, len(records))
+	for _, record := range records[1:] {
+		digits, err := strconv.Atoi(record[1])
+		if err != nil {
+			panic("invalid minor units of currency " + record[0])
+		}
+		table[record[0]] = digits
+	}
+	return table
+}
+
+func parseLanguages(data []byte) map[string]bool {
+	table := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, code := range strings.Fields(line) {
+			table[code] = true
+		}
+	}
+	return table
+}
+
+// MinorUnits returns the number of decimal digits of the minor unit of an
+// ISO 4217 currency, such as 2 for "USD" and 0 for "JPY". It reports false
+// if code is not an active currency code.
+func MinorUnits(code string) (int, bool) {
+	digits, ok := currencies[code]
+	return digits, ok
+}
+
+// IsCurrency reports whether code is an active ISO 4217 currency code.
+func IsCurrency(code string) bool {
+	_, ok := currencies[code]
+	return ok
+}
+
+// IsLanguageTag reports whether tag is a BCP 47 language tag made of an
+// ISO 639-1 language and optional script, region and variant subtags, such
+// as "en", "en-US" or "sr-Latn-RS". Subtags are case-insensitive.
+// Extensions, private use subtags and three-letter languages are not
+// accepted.
+func IsLanguageTag(tag string) bool {
+	subtags := strings.Split(strings.ToLower(tag), "-")
+	if !languages[subtags[0]] {
+		return false
+	}
+	subtags = subtags[1:]
+
+	if len(subtags) > 0 && len(subtags[0]) == 4 && isAlpha(subtags[0]) {
+		subtags = subtags[1:]
+	}
+	if len(subtags) > 0 && (len(subtags[0]) == 2 && isAlpha(subtags[0]) || len(subtags[0]) == 3 && isDigit(subtags[0])) {
+		subtags = subtags[1:]
+	}
+	for _, variant := range subtags {
+		if !isVariant(variant) {
+			return false
+		}
+	}
+	return true
+}
+
+func isVariant(s string) bool {
+	if len(s) == 4 {
+		return isDigit(s[:1]) && isAlphanumeric(s)
+	}
+	return len(s) >= 5 && len(s) <= 8 && isAlphanumeric(s)
+}
+
+func isAlpha(s string) bool {
+	for _, c := range s {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(s string) bool {
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
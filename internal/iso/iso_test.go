@@ -0,0 +1,29 @@
+package iso
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinorUnits(t *testing.T) {
+	for code, want := range map[string]int{"USD": 2, "RUB": 2, "JPY": 0, "KWD": 3, "CLF": 4} {
+		digits, ok := MinorUnits(code)
+		assert.True(t, ok, code)
+		assert.Equal(t, want, digits, code)
+	}
+
+	for _, code := range []string{"", "XYZ", "usd", "US", "HRK", "XAU"} {
+		assert.False(t, IsCurrency(code), code)
+	}
+}
+
+func TestIsLanguageTag(t *testing.T) {
+	for _, tag := range []string{"en", "ru", "EN-us", "zh-Hant-TW", "sr-Latn-RS", "es-419", "sl-rozaj", "de-CH-1901"} {
+		assert.True(t, IsLanguageTag(tag), tag)
+	}
+	for _, tag := range []string{"", "klingon", "xx", "en_US", "en-", "-en", "en-USA", "en-US-x-private", "eng", "en-a-bbb"} {
+		assert.False(t, IsLanguageTag(tag), tag)
+	}
+	assert.Len(t, languages, 183)
+}
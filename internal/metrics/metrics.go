@@ -11,6 +11,11 @@ var (
 		Help: "Total number of processed orders",
 	})
 
+	OrdersUpdated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_updated_total",
+		Help: "Total number of orders replaced by a newer version",
+	})
+
 	OrdersFromCache = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "orders_from_cache_total",
 		Help: "Total number of orders served from cache",
@@ -21,6 +26,16 @@ var (
 		Help: "Total number of orders served from database",
 	})
 
+	OrdersCoalesced = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_coalesced_total",
+		Help: "Total number of order requests that waited for a concurrent database load instead of starting their own",
+	})
+
+	ValidationWarnings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "validation_warnings_total",
+		Help: "Total number of consistency warnings recorded on orders, by rule",
+	}, []string{"rule"})
+
 	CacheSize = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "cache_size_bytes",
 		Help: "Current size of cache in bytes",
@@ -31,11 +46,41 @@ var (
 		Help: "Current number of items in cache",
 	})
 
+	CacheNegativeItems = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_negative_items_count",
+		Help: "Current number of order uids cached as not found",
+	})
+
+	CacheNegativeHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cache_negative_hits_total",
+		Help: "Total number of lookups answered from the cache of unknown order uids",
+	})
+
+	CacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_evictions_total",
+		Help: "Total number of cache entries evicted to make room, by eviction policy",
+	}, []string{"policy"})
+
+	CacheInvalidations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_invalidations_total",
+		Help: "Total number of cache invalidation events received, by operation",
+	}, []string{"op"})
+
 	KafkaMessagesReceived = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "kafka_messages_received_total",
 		Help: "Total number of messages received from Kafka",
 	})
 
+	KafkaRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_message_retries_total",
+		Help: "Total number of Kafka message processing retries",
+	})
+
+	KafkaDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_messages_dead_lettered_total",
+		Help: "Total number of Kafka messages published to the dead-letter topic",
+	})
+
 	DBErrors = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "db_errors_total",
 		Help: "Total number of database errors",
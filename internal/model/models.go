@@ -1,7 +1,11 @@
 package models
 
 import (
+	"database/sql/driver"
+	"errors"
 	"time"
+
+	"github.com/goccy/go-json"
 )
 
 type Order struct {
@@ -16,13 +20,58 @@ type Order struct {
 	SmID              int       `json:"sm_id" db:"sm_id"`
 	DateCreated       time.Time `json:"date_created" db:"date_created"`
 	OofShard          string    `json:"oof_shard" db:"oof_shard"`
-	CreatedAt         time.Time `json:"updated_at" db:"updated_at"`
+	Version           int       `json:"version" db:"version"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
+	Warnings          Warnings  `json:"warnings,omitempty" db:"validation_warnings"`
 
 	Delivery Delivery `json:"delivery"`
 	Payment  Payment  `json:"payment"`
 	Items    []Item   `json:"items"`
 }
 
+// Warning records a consistency rule an order was accepted in spite of.
+type Warning struct {
+	Rule    string `json:"rule"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Warnings is stored as a JSONB array.
+type Warnings []Warning
+
+func (w Warnings) Value() (driver.Value, error) {
+	if w == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(w)
+}
+
+func (w *Warnings) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*w = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("unsupported type for warnings")
+	}
+
+	var warnings Warnings
+	if err := json.Unmarshal(data, &warnings); err != nil {
+		return err
+	}
+	if len(warnings) == 0 {
+		warnings = nil
+	}
+	*w = warnings
+	return nil
+}
+
 type Delivery struct {
 	ID       int    `json:"-" db:"id"`
 	OrderUID string `json:"-" db:"order_uid"`
@@ -36,32 +85,32 @@ type Delivery struct {
 }
 
 type Payment struct {
-	ID           int     `json:"-" db:"id"`
-	OrderUID     string  `json:"-" db:"order_uid"`
-	Transaction  string  `json:"transaction" db:"transaction"`
-	RequestID    string  `json:"request_id" db:"request_id"`
-	Currency     string  `json:"currency" db:"currency"`
-	Provider     string  `json:"provider" db:"provider"`
-	Amount       float64 `json:"amount" db:"amount"`
-	PaymentDT    int64   `json:"payment_dt" db:"payment_dt"`
-	Bank         string  `json:"bank" db:"bank"`
-	DeliveryCost float64 `json:"delivery_cost" db:"delivery_cost"`
-	GoodsTotal   float64 `json:"goods_total" db:"goods_total"`
-	CustomFee    float64 `json:"custom_fee" db:"custom_fee"`
+	ID           int    `json:"-" db:"id"`
+	OrderUID     string `json:"-" db:"order_uid"`
+	Transaction  string `json:"transaction" db:"transaction"`
+	RequestID    string `json:"request_id" db:"request_id"`
+	Currency     string `json:"currency" db:"currency"`
+	Provider     string `json:"provider" db:"provider"`
+	Amount       Money  `json:"amount" db:"amount"`
+	PaymentDT    int64  `json:"payment_dt" db:"payment_dt"`
+	Bank         string `json:"bank" db:"bank"`
+	DeliveryCost Money  `json:"delivery_cost" db:"delivery_cost"`
+	GoodsTotal   Money  `json:"goods_total" db:"goods_total"`
+	CustomFee    Money  `json:"custom_fee" db:"custom_fee"`
 }
 
 type Item struct {
-	ID          int     `json:"-" db:"id"`
-	OrderUID    string  `json:"-" db:"order_uid"`
-	ChrtID      int     `json:"chrt_id" db:"chrt_id"`
-	TrackNumber string  `json:"track_number" db:"track_number"`
-	Price       float64 `json:"price" db:"price"`
-	Rid         string  `json:"rid" db:"rid"`
-	Name        string  `json:"name" db:"name"`
-	Sale        int     `json:"sale" db:"sale"`
-	Size        string  `json:"size" db:"size"`
-	TotalPrice  float64 `json:"total_price" db:"total_price"`
-	NmID        int     `json:"nm_id" db:"nm_id"`
-	Brand       string  `json:"brand" db:"brand"`
-	Status      int     `json:"status" db:"status"`
+	ID          int    `json:"-" db:"id"`
+	OrderUID    string `json:"-" db:"order_uid"`
+	ChrtID      int    `json:"chrt_id" db:"chrt_id"`
+	TrackNumber string `json:"track_number" db:"track_number"`
+	Price       Money  `json:"price" db:"price"`
+	Rid         string `json:"rid" db:"rid"`
+	Name        string `json:"name" db:"name"`
+	Sale        int    `json:"sale" db:"sale"`
+	Size        string `json:"size" db:"size"`
+	TotalPrice  Money  `json:"total_price" db:"total_price"`
+	NmID        int    `json:"nm_id" db:"nm_id"`
+	Brand       string `json:"brand" db:"brand"`
+	Status      int    `json:"status" db:"status"`
 }
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarnings_ValueScan(t *testing.T) {
+	var none Warnings
+	value, err := none.Value()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("[]"), value)
+
+	warnings := Warnings{{Rule: "amount", Field: "payment.amount", Message: "must add up"}}
+	value, err = warnings.Value()
+	require.NoError(t, err)
+
+	var scanned Warnings
+	require.NoError(t, scanned.Scan(value))
+	assert.Equal(t, warnings, scanned)
+
+	require.NoError(t, scanned.Scan("[]"))
+	assert.Nil(t, scanned)
+
+	require.NoError(t, scanned.Scan(nil))
+	assert.Nil(t, scanned)
+
+	assert.Error(t, scanned.Scan(42))
+	assert.Error(t, scanned.Scan([]byte("{")))
+}
+
+func TestParseMoney(t *testing.T) {
+	for s, want := range map[string]Money{
+		"1817":                 18170000,
+		"317.1":                3171000,
+		"-0.5":                 -5000,
+		"0.0001":               1,
+		"+453.0000":            4530000,
+		"922337203685477.5807": math.MaxInt64,
+	} {
+		money, err := ParseMoney(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, money, s)
+	}
+
+	for _, s := range []string{
+		"", "abc", "1/2", "-", ".5", "1.", "1.2.3", "0x10", " 1", "1e3", "1e1000000",
+		"0.00001", "453.00000", "922337203685477.5808", "1000000000000000",
+	} {
+		_, err := ParseMoney(s)
+		assert.Error(t, err, s)
+	}
+
+	// Unlike floats, amounts add up exactly.
+	a, _ := ParseMoney("0.1")
+	b, _ := ParseMoney("0.2")
+	assert.Equal(t, "0.3", (a + b).String())
+}
+
+func TestMoney_Percent(t *testing.T) {
+	assert.Equal(t, MoneyFromFloat(317.1), MoneyFromFloat(453).Percent(70))
+	assert.Equal(t, MoneyFromFloat(0.0002), MoneyFromFloat(0.0003).Percent(50))
+	assert.Equal(t, MoneyFromFloat(-0.0002), MoneyFromFloat(-0.0003).Percent(50))
+	assert.Equal(t, Money(math.MaxInt64/100*100), Money(math.MaxInt64/100*100).Percent(100))
+}
+
+func TestMoney_JSON(t *testing.T) {
+	var payment Payment
+	require.NoError(t, json.Unmarshal([]byte(`{"amount": 1817.1, "goods_total": 0.1, "delivery_cost": 1500, "custom_fee": null}`), &payment))
+	assert.Equal(t, MoneyFromFloat(1817.1), payment.Amount)
+	assert.Equal(t, MoneyFromFloat(0.1), payment.GoodsTotal)
+	assert.Equal(t, MoneyFromFloat(1500), payment.DeliveryCost)
+	assert.Equal(t, Money(0), payment.CustomFee)
+
+	data, err := json.Marshal(Item{Price: MoneyFromFloat(453), TotalPrice: MoneyFromFloat(-317.0001)})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"price":453,`)
+	assert.Contains(t, string(data), `"total_price":-317.0001,`)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"amount": 0.00001}`), &payment))
+	assert.Error(t, json.Unmarshal([]byte(`{"amount": "10"}`), &payment))
+	assert.Error(t, json.Unmarshal([]byte(`{"amount": 1.5e3}`), &payment))
+}
+
+// TestMoney_RoundTrip follows an amount from a JSON order to a NUMERIC
+// column and back. lib/pq scans NUMERIC as its text form, padded to the
+// scale of the column.
+func TestMoney_RoundTrip(t *testing.T) {
+	for _, amount := range []string{"0.1", "317.1", "1817", "9999999999999.99", "-0.05"} {
+		var item Item
+		require.NoError(t, json.Unmarshal([]byte(`{"price": `+amount+`}`), &item))
+
+		value, err := item.Price.Value()
+		require.NoError(t, err)
+		assert.Equal(t, amount, value)
+
+		column := value.(string)
+		if !strings.Contains(column, ".") {
+			column += "."
+		}
+		for len(column)-strings.Index(column, ".") <= 2 {
+			column += "0"
+		}
+
+		var scanned Money
+		require.NoError(t, scanned.Scan([]byte(column)))
+		assert.Equal(t, item.Price, scanned, column)
+
+		data, err := json.Marshal(scanned)
+		require.NoError(t, err)
+		assert.Equal(t, amount, string(data))
+	}
+
+	var scanned Money
+	require.NoError(t, scanned.Scan(int64(5)))
+	assert.Equal(t, MoneyFromFloat(5), scanned)
+	assert.Error(t, scanned.Scan(int64(math.MaxInt64/moneyScale+1)))
+	assert.Error(t, scanned.Scan(true))
+	assert.Error(t, scanned.Scan("1.00001"))
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MoneyDigits is the number of decimal digits kept by Money, enough for
+// the minor unit of every ISO 4217 currency.
+const MoneyDigits = 4
+
+const moneyScale = 10000
+
+// Money is an exact decimal amount, stored as a whole number of
+// ten-thousandths. It is written to JSON as a number and to Postgres as a
+// NUMERIC literal, so amounts survive both without rounding.
+type Money int64
+
+// MoneyFromFloat returns f rounded to MoneyDigits decimal digits.
+func MoneyFromFloat(f float64) Money {
+	return Money(math.Round(f * moneyScale))
+}
+
+// maxMoneyIntDigits is the number of integer digits ParseMoney accepts:
+// Money holds up to about 9.2e14.
+const maxMoneyIntDigits = 15
+
+// ParseMoney parses a decimal number such as "1817" or "-0.5". Exponents,
+// more than MoneyDigits decimal places and amounts too large for Money are
+// rejected rather than rounded, so parsing never does more than a bounded
+// amount of work.
+func ParseMoney(s string) (Money, error) {
+	digits := s
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	whole, frac, hasFrac := strings.Cut(digits, ".")
+	if !isDigits(whole) || hasFrac && !isDigits(frac) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(frac) > MoneyDigits {
+		return 0, fmt.Errorf("amount %s has more than %d decimal places", s, MoneyDigits)
+	}
+	if len(whole) > maxMoneyIntDigits {
+		return 0, fmt.Errorf("amount %s is out of range", s)
+	}
+
+	units := s[:len(s)-len(digits)] + whole + frac + strings.Repeat("0", MoneyDigits-len(frac))
+	n, err := strconv.ParseInt(units, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("amount %s is out of range", s)
+	}
+	return Money(n), nil
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Float64 returns m as a float, for display and approximate comparisons.
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+// Percent returns percent per cent of m, rounded half away from zero.
+func (m Money) Percent(percent int) Money {
+	// Splitting off the last two digits keeps the product from overflowing.
+	whole, rest := m/100*Money(percent), m%100*Money(percent)
+	if rest < 0 {
+		return whole + (rest-50)/100
+	}
+	return whole + (rest+50)/100
+}
+
+// String formats m without trailing zeros, such as "1817" or "317.1".
+func (m Money) String() string {
+	sign, abs := "", uint64(m)
+	if m < 0 {
+		sign, abs = "-", uint64(-m)
+	}
+
+	s := strconv.FormatUint(abs/moneyScale, 10)
+	if frac := abs % moneyScale; frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%0*d", MoneyDigits, frac), "0")
+	}
+	return sign + s
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		return fmt.Errorf("amount %s must be a number", s)
+	}
+
+	money, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = money
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	case int64:
+		if v > math.MaxInt64/moneyScale || v < math.MinInt64/moneyScale {
+			return fmt.Errorf("amount %d is out of range", v)
+		}
+		*m = Money(v * moneyScale)
+		return nil
+	case float64:
+		*m = MoneyFromFloat(v)
+		return nil
+	default:
+		return errors.New("unsupported type for money")
+	}
+}
+
+func (m *Money) scanString(s string) error {
+	money, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = money
+	return nil
+}
@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"L0/internal/model"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres accepts at most 65535 bind parameters per statement.
+const maxBindParams = 65535
+
+var (
+	orderColumns = []string{
+		"order_uid", "track_number", "entry", "locale", "internal_signature", "customer_id",
+		"delivery_service", "shardkey", "sm_id", "date_created", "oof_shard", "version",
+		"validation_warnings",
+	}
+	deliveryColumns = []string{
+		"order_uid", "name", "phone", "zip", "city", "address", "region", "email",
+	}
+	paymentColumns = []string{
+		"order_uid", "transaction", "request_id", "currency", "provider", "amount",
+		"payment_dt", "bank", "delivery_cost", "goods_total", "custom_fee",
+	}
+	itemColumns = []string{
+		"order_uid", "chrt_id", "track_number", "price", "rid", "name", "sale", "size",
+		"total_price", "nm_id", "brand", "status",
+	}
+)
+
+func orderRow(o *models.Order) []any {
+	return []any{
+		o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerID,
+		o.DeliveryService, o.Shardkey, o.SmID, o.DateCreated, o.OofShard, o.Version,
+		o.Warnings,
+	}
+}
+
+func deliveryRow(o *models.Order) []any {
+	d := o.Delivery
+	return []any{o.OrderUID, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email}
+}
+
+func paymentRow(o *models.Order) []any {
+	p := o.Payment
+	return []any{
+		o.OrderUID, p.Transaction, p.RequestID, p.Currency, p.Provider, p.Amount,
+		p.PaymentDT, p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee,
+	}
+}
+
+func itemRows(o *models.Order) [][]any {
+	rows := make([][]any, 0, len(o.Items))
+	for _, item := range o.Items {
+		rows = append(rows, []any{
+			o.OrderUID, item.ChrtID, item.TrackNumber, item.Price, item.Rid, item.Name, item.Sale,
+			item.Size, item.TotalPrice, item.NmID, item.Brand, item.Status,
+		})
+	}
+	return rows
+}
+
+// insertQuery builds a multi-row INSERT statement for n rows.
+func insertQuery(table string, columns []string, n int) string {
+	var b strings.Builder
+	b.WriteString("INSERT INTO ")
+	b.WriteString(table)
+	b.WriteString(" (")
+	b.WriteString(strings.Join(columns, ", "))
+	b.WriteString(") VALUES ")
+
+	param := 1
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for j := range columns {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "$%d", param)
+			param++
+		}
+		b.WriteByte(')')
+	}
+
+	return b.String()
+}
+
+// chunkRows splits rows so that every chunk fits into a single statement.
+func chunkRows(rows [][]any, columns int) [][][]any {
+	size := maxBindParams / columns
+	var chunks [][][]any
+	for len(rows) > size {
+		chunks = append(chunks, rows[:size])
+		rows = rows[size:]
+	}
+	if len(rows) > 0 {
+		chunks = append(chunks, rows)
+	}
+	return chunks
+}
+
+func flatten(rows [][]any) []any {
+	args := make([]any, 0, len(rows)*len(rows[0]))
+	for _, row := range rows {
+		args = append(args, row...)
+	}
+	return args
+}
+
+func insertRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any) error {
+	for _, chunk := range chunkRows(rows, len(columns)) {
+		if _, err := tx.ExecContext(ctx, insertQuery(table, columns, len(chunk)), flatten(chunk)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertOrder writes a single order with its delivery, payment and items,
+// and sets the timestamps the database gave it.
+func insertOrder(ctx context.Context, tx *sql.Tx, order *models.Order) error {
+	query := insertQuery("orders", orderColumns, 1) + " RETURNING created_at, updated_at"
+	if err := tx.QueryRowContext(ctx, query, orderRow(order)...).Scan(&order.CreatedAt, &order.UpdatedAt); err != nil {
+		return err
+	}
+	return insertOrderDetails(ctx, tx, order)
+}
+
+// insertOrderDetails writes the delivery, payment and items of an order.
+func insertOrderDetails(ctx context.Context, tx *sql.Tx, order *models.Order) error {
+	if err := insertRows(ctx, tx, "deliveries", deliveryColumns, [][]any{deliveryRow(order)}); err != nil {
+		return err
+	}
+	if err := insertRows(ctx, tx, "payments", paymentColumns, [][]any{paymentRow(order)}); err != nil {
+		return err
+	}
+	if len(order.Items) == 0 {
+		return nil
+	}
+	return insertRows(ctx, tx, "items", itemColumns, itemRows(order))
+}
+
+// SaveOrders stores orders in a single transaction using multi-row inserts.
+// The returned slice holds a per-order error aligned with orders; an order
+// that is already stored gets ErrOrderExists. So does every copy of an order
+// after the first one in orders, whatever its version: the caller applies
+// them as updates, in order, after the batch. If a statement of the batch
+// fails, the orders are written again one by one, each behind a savepoint,
+// so that a bad order fails only itself. The second return value is set
+// when the transaction as a whole could not be committed.
+func (r *PostgresRepository) SaveOrders(ctx context.Context, orders []*models.Order) ([]error, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	errs, err := r.saveOrdersBatch(ctx, orders)
+	if err == nil {
+		return errs, nil
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return nil, err
+	}
+
+	return r.saveOrdersOneByOne(ctx, orders)
+}
+
+func (r *PostgresRepository) saveOrdersBatch(ctx context.Context, orders []*models.Order) ([]error, error) {
+	errs := make([]error, len(orders))
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pending := make(map[string]*models.Order, len(orders))
+	var rows [][]any
+	for i, order := range orders {
+		if pending[order.OrderUID] != nil {
+			errs[i] = ErrOrderExists
+			continue
+		}
+		pending[order.OrderUID] = order
+		rows = append(rows, orderRow(order))
+	}
+
+	inserted := make(map[string]bool, len(rows))
+	for _, chunk := range chunkRows(rows, len(orderColumns)) {
+		query := insertQuery("orders", orderColumns, len(chunk)) +
+			" ON CONFLICT (order_uid) DO NOTHING RETURNING order_uid, created_at, updated_at"
+		result, err := tx.QueryContext(ctx, query, flatten(chunk)...)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next() {
+			var uid string
+			var createdAt, updatedAt time.Time
+			if err := result.Scan(&uid, &createdAt, &updatedAt); err != nil {
+				result.Close()
+				return nil, err
+			}
+			inserted[uid] = true
+			pending[uid].CreatedAt, pending[uid].UpdatedAt = createdAt, updatedAt
+		}
+		result.Close()
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var deliveries, payments, items [][]any
+	for i, order := range orders {
+		if errs[i] != nil {
+			continue
+		}
+		if !inserted[order.OrderUID] {
+			errs[i] = ErrOrderExists
+			continue
+		}
+		deliveries = append(deliveries, deliveryRow(order))
+		payments = append(payments, paymentRow(order))
+		items = append(items, itemRows(order)...)
+	}
+
+	if len(deliveries) > 0 {
+		if err := insertRows(ctx, tx, "deliveries", deliveryColumns, deliveries); err != nil {
+			return nil, err
+		}
+		if err := insertRows(ctx, tx, "payments", paymentColumns, payments); err != nil {
+			return nil, err
+		}
+	}
+	if len(items) > 0 {
+		if err := insertRows(ctx, tx, "items", itemColumns, items); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+func (r *PostgresRepository) saveOrdersOneByOne(ctx context.Context, orders []*models.Order) ([]error, error) {
+	errs := make([]error, len(orders))
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, order := range orders {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT save_order"); err != nil {
+			return nil, err
+		}
+
+		if err := insertOrder(ctx, tx, order); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+				err = ErrOrderExists
+			}
+			errs[i] = err
+
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT save_order"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT save_order"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
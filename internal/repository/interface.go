@@ -1,12 +1,23 @@
 package repository
 
-import "L0/internal/model"
+import (
+	"L0/internal/model"
+	"context"
+)
 
 type Repository interface {
-	SaveOrder(order *models.Order) error
-	GetOrderByID(orderUID string) (*models.Order, error)
-	LoadAllOrders() ([]*models.Order, error)
-	OrderExists(orderUID string) (bool, error)
-	Ping() error
+	SaveOrder(ctx context.Context, order *models.Order) error
+	SaveOrders(ctx context.Context, orders []*models.Order) ([]error, error)
+	UpdateOrder(ctx context.Context, order *models.Order) error
+	GetOrderByID(ctx context.Context, orderUID string) (*models.Order, error)
+	GetOrdersByIDs(ctx context.Context, orderUIDs []string) ([]*models.Order, error)
+	StreamOrders(ctx context.Context, filter OrderFilter, chunkSize int, fn func([]*models.Order) error) error
+	ListOrders(ctx context.Context, filter OrderFilter) ([]*models.Order, error)
+	FindOrdersByTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error)
+	FindOrdersByItemTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error)
+	FindOrdersByChrtID(ctx context.Context, chrtID int) ([]*models.Order, error)
+	FindOrdersByNmID(ctx context.Context, nmID int) ([]*models.Order, error)
+	OrderExists(ctx context.Context, orderUID string) (bool, error)
+	Ping(ctx context.Context) error
 	Close() error
 }
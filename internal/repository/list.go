@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"L0/internal/model"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OrderFilter selects orders for ListOrders. Zero-valued fields are ignored.
+type OrderFilter struct {
+	CustomerID      string
+	TrackNumber     string
+	DeliveryService string
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	Currency        string
+	Provider        string
+	Brand           string
+	// UpdatedSince selects orders stored or changed after the given time.
+	// updated_at is set when a row is written, not when it commits, so
+	// callers that poll for changes should overlap their windows by the
+	// longest write transaction.
+	UpdatedSince time.Time
+
+	// AfterDate and AfterUID continue a listing after the given order.
+	// Orders are sorted by date_created and order_uid, newest first.
+	AfterDate time.Time
+	AfterUID  string
+	Limit     int
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// ListOrders returns fully assembled orders matching filter, newest first.
+func (r *PostgresRepository) ListOrders(ctx context.Context, filter OrderFilter) ([]*models.Order, error) {
+	query, args := buildListQuery(filter)
+	return r.findOrders(ctx, query, args...)
+}
+
+// buildListQuery returns the query selecting the order_uids for ListOrders.
+func buildListQuery(filter OrderFilter) (string, []any) {
+	var (
+		conds []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.CustomerID != "" {
+		conds = append(conds, "o.customer_id = "+arg(filter.CustomerID))
+	}
+	if filter.TrackNumber != "" {
+		conds = append(conds, "o.track_number = "+arg(filter.TrackNumber))
+	}
+	if filter.DeliveryService != "" {
+		conds = append(conds, "o.delivery_service = "+arg(filter.DeliveryService))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		conds = append(conds, "o.date_created >= "+arg(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		conds = append(conds, "o.date_created < "+arg(filter.CreatedTo))
+	}
+	if filter.Currency != "" {
+		conds = append(conds, "p.currency = "+arg(filter.Currency))
+	}
+	if filter.Provider != "" {
+		conds = append(conds, "p.provider = "+arg(filter.Provider))
+	}
+	if filter.Brand != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.brand = "+
+			arg(filter.Brand)+")")
+	}
+	if !filter.UpdatedSince.IsZero() {
+		conds = append(conds, "o.updated_at > "+arg(filter.UpdatedSince))
+	}
+	if filter.AfterUID != "" {
+		conds = append(conds, "(o.date_created, o.order_uid) < ("+arg(filter.AfterDate)+", "+arg(filter.AfterUID)+")")
+	}
+
+	query := "SELECT o.order_uid FROM orders o"
+	if filter.Currency != "" || filter.Provider != "" {
+		query += " JOIN payments p ON p.order_uid = o.order_uid"
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY o.date_created DESC, o.order_uid DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	return query, args
+}
+
+func queryOrderUIDs(ctx context.Context, q querier, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uids []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+
+	return uids, rows.Err()
+}
+
+// loadOrders assembles the orders with the given uids using two queries and
+// returns them in the order of uids. Unknown uids are skipped.
+func loadOrders(ctx context.Context, q querier, uids []string) ([]*models.Order, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT o.order_uid, o.track_number, o.entry, o.locale, o.internal_signature, o.customer_id,
+		       o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.oof_shard, o.version, o.created_at,
+		       o.updated_at, o.validation_warnings,
+		       COALESCE(d.name, ''), COALESCE(d.phone, ''), COALESCE(d.zip, ''), COALESCE(d.city, ''),
+		       COALESCE(d.address, ''), COALESCE(d.region, ''), COALESCE(d.email, ''),
+		       COALESCE(p.transaction, ''), COALESCE(p.request_id, ''), COALESCE(p.currency, ''),
+		       COALESCE(p.provider, ''), COALESCE(p.amount, 0), COALESCE(p.payment_dt, 0),
+		       COALESCE(p.bank, ''), COALESCE(p.delivery_cost, 0), COALESCE(p.goods_total, 0),
+		       COALESCE(p.custom_fee, 0)
+		FROM orders o
+		LEFT JOIN deliveries d ON d.order_uid = o.order_uid
+		LEFT JOIN payments p ON p.order_uid = o.order_uid
+		WHERE o.order_uid = ANY($1)
+	`, pq.Array(uids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byUID := make(map[string]*models.Order, len(uids))
+	for rows.Next() {
+		var order models.Order
+		err := rows.Scan(
+			&order.OrderUID, &order.TrackNumber, &order.Entry, &order.Locale,
+			&order.InternalSignature, &order.CustomerID, &order.DeliveryService,
+			&order.Shardkey, &order.SmID, &order.DateCreated, &order.OofShard, &order.Version, &order.CreatedAt,
+			&order.UpdatedAt, &order.Warnings,
+			&order.Delivery.Name, &order.Delivery.Phone, &order.Delivery.Zip, &order.Delivery.City,
+			&order.Delivery.Address, &order.Delivery.Region, &order.Delivery.Email,
+			&order.Payment.Transaction, &order.Payment.RequestID, &order.Payment.Currency,
+			&order.Payment.Provider, &order.Payment.Amount, &order.Payment.PaymentDT,
+			&order.Payment.Bank, &order.Payment.DeliveryCost, &order.Payment.GoodsTotal,
+			&order.Payment.CustomFee,
+		)
+		if err != nil {
+			return nil, err
+		}
+		byUID[order.OrderUID] = &order
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	items, err := q.QueryContext(ctx, `
+		SELECT order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status
+		FROM items WHERE order_uid = ANY($1)
+		ORDER BY id
+	`, pq.Array(uids))
+	if err != nil {
+		return nil, err
+	}
+	defer items.Close()
+
+	for items.Next() {
+		var uid string
+		var item models.Item
+		err := items.Scan(
+			&uid, &item.ChrtID, &item.TrackNumber, &item.Price, &item.Rid,
+			&item.Name, &item.Sale, &item.Size, &item.TotalPrice,
+			&item.NmID, &item.Brand, &item.Status,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if order, ok := byUID[uid]; ok {
+			order.Items = append(order.Items, item)
+		}
+	}
+	if err := items.Err(); err != nil {
+		return nil, err
+	}
+
+	orders := make([]*models.Order, 0, len(byUID))
+	for _, uid := range uids {
+		if order, ok := byUID[uid]; ok {
+			orders = append(orders, order)
+		}
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"L0/internal/model"
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// FindOrdersByTrackNumber returns the orders with the given order track number.
+func (r *PostgresRepository) FindOrdersByTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error) {
+	return r.findOrders(ctx, `
+		SELECT order_uid FROM orders WHERE track_number = $1
+		ORDER BY date_created DESC, order_uid DESC
+	`, trackNumber)
+}
+
+// FindOrdersByItemTrackNumber returns the orders having an item with the given track number.
+func (r *PostgresRepository) FindOrdersByItemTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error) {
+	return r.findOrders(ctx, `
+		SELECT o.order_uid FROM orders o
+		WHERE EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.track_number = $1)
+		ORDER BY o.date_created DESC, o.order_uid DESC
+	`, trackNumber)
+}
+
+// FindOrdersByChrtID returns the orders having an item with the given chrt_id.
+func (r *PostgresRepository) FindOrdersByChrtID(ctx context.Context, chrtID int) ([]*models.Order, error) {
+	return r.findOrders(ctx, `
+		SELECT o.order_uid FROM orders o
+		WHERE EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.chrt_id = $1)
+		ORDER BY o.date_created DESC, o.order_uid DESC
+	`, chrtID)
+}
+
+// FindOrdersByNmID returns the orders having an item with the given nm_id.
+func (r *PostgresRepository) FindOrdersByNmID(ctx context.Context, nmID int) ([]*models.Order, error) {
+	return r.findOrders(ctx, `
+		SELECT o.order_uid FROM orders o
+		WHERE EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.nm_id = $1)
+		ORDER BY o.date_created DESC, o.order_uid DESC
+	`, nmID)
+}
+
+// GetOrdersByIDs returns the orders with the given uids in the same order.
+// Unknown uids are skipped.
+func (r *PostgresRepository) GetOrdersByIDs(ctx context.Context, orderUIDs []string) ([]*models.Order, error) {
+	return r.findOrders(ctx, `
+		SELECT order_uid FROM orders WHERE order_uid = ANY($1)
+		ORDER BY array_position($1, order_uid)
+	`, pq.Array(orderUIDs))
+}
+
+func (r *PostgresRepository) findOrders(ctx context.Context, query string, args ...any) ([]*models.Order, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	uids, err := queryOrderUIDs(ctx, tx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := loadOrders(ctx, tx, uids)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
@@ -7,18 +7,43 @@ import (
 	"errors"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+var (
+	// ErrOrderExists is returned by SaveOrder when the order_uid is already stored.
+	ErrOrderExists = errors.New("order already exists")
+	// ErrOrderNotFound is returned by UpdateOrder for an unknown order_uid.
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrStaleVersion is returned by UpdateOrder when the stored order has
+	// the same or a newer version than the update.
+	ErrStaleVersion = errors.New("stale order version")
+)
+
+const uniqueViolation = "23505"
+
+// Timeouts bound how long each kind of operation may take, on top of the
+// context it is given. A zero timeout leaves the operation unbounded.
+type Timeouts struct {
+	// Read covers loading single orders, lookups, listings and existence
+	// checks.
+	Read time.Duration
+	// Write covers storing and updating orders, including batches.
+	Write time.Duration
+	// Stream covers a whole StreamOrders call.
+	Stream time.Duration
+}
+
 type PostgresRepository struct {
-	db *sql.DB
+	db       *sql.DB
+	timeouts Timeouts
 }
 
 func (r *PostgresRepository) DB() *sql.DB {
 	return r.db
 }
 
-func New(dsn string) (*PostgresRepository, error) {
+func New(dsn string, timeouts Timeouts) (*PostgresRepository, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
@@ -32,11 +57,19 @@ func New(dsn string) (*PostgresRepository, error) {
 	db.SetMaxIdleConns(25)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	return &PostgresRepository{db: db}, nil
+	return &PostgresRepository{db: db, timeouts: timeouts}, nil
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
-func (r *PostgresRepository) SaveOrder(order *models.Order) error {
-	ctx := context.Background()
+func (r *PostgresRepository) SaveOrder(ctx context.Context, order *models.Order) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
 
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{
 		Isolation: sql.LevelRepeatableRead,
@@ -46,66 +79,88 @@ func (r *PostgresRepository) SaveOrder(order *models.Order) error {
 	}
 	defer tx.Rollback()
 
-	exists, err := r.OrderExists(order.OrderUID)
+	// An order stored concurrently is not visible to the transaction, but
+	// fails the insert with a unique violation.
+	var exists bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM orders WHERE order_uid = $1)
+	`, order.OrderUID).Scan(&exists)
 	if err != nil {
 		return err
 	}
 	if exists {
-		return errors.New("order already exists")
+		return ErrOrderExists
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO orders 
-		(order_uid, track_number, entry, locale, internal_signature, customer_id, 
-		 delivery_service, shardkey, sm_id, date_created, oof_shard) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-	`, order.OrderUID, order.TrackNumber, order.Entry, order.Locale, order.InternalSignature,
-		order.CustomerID, order.DeliveryService, order.Shardkey, order.SmID,
-		order.DateCreated, order.OofShard)
+	if err := insertOrder(ctx, tx, order); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return ErrOrderExists
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateOrder replaces a stored order together with its delivery, payment
+// and items. The update is applied only if order.Version is greater than
+// the stored version; otherwise ErrStaleVersion is returned.
+func (r *PostgresRepository) UpdateOrder(ctx context.Context, order *models.Order) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+	})
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO deliveries 
-		(order_uid, name, phone, zip, city, address, region, email)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`, order.OrderUID, order.Delivery.Name, order.Delivery.Phone, order.Delivery.Zip,
-		order.Delivery.City, order.Delivery.Address, order.Delivery.Region, order.Delivery.Email)
+	var current int
+	err = tx.QueryRowContext(ctx, `
+		SELECT version FROM orders WHERE order_uid = $1 FOR UPDATE
+	`, order.OrderUID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return ErrOrderNotFound
+	}
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO payments 
-		(order_uid, transaction, request_id, currency, provider, amount, 
-		 payment_dt, bank, delivery_cost, goods_total, custom_fee)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-	`, order.OrderUID, order.Payment.Transaction, order.Payment.RequestID,
-		order.Payment.Currency, order.Payment.Provider, order.Payment.Amount,
-		order.Payment.PaymentDT, order.Payment.Bank, order.Payment.DeliveryCost,
-		order.Payment.GoodsTotal, order.Payment.CustomFee)
+	if order.Version <= current {
+		return ErrStaleVersion
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE orders
+		SET track_number = $2, entry = $3, locale = $4, internal_signature = $5, customer_id = $6,
+		    delivery_service = $7, shardkey = $8, sm_id = $9, date_created = $10, oof_shard = $11,
+		    version = $12, validation_warnings = $13
+		WHERE order_uid = $1
+		RETURNING created_at, updated_at
+	`, orderRow(order)...).Scan(&order.CreatedAt, &order.UpdatedAt)
 	if err != nil {
 		return err
 	}
 
-	for _, item := range order.Items {
-		_, err = tx.ExecContext(ctx, `
-			INSERT INTO items 
-			(order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-		`, order.OrderUID, item.ChrtID, item.TrackNumber, item.Price, item.Rid,
-			item.Name, item.Sale, item.Size, item.TotalPrice, item.NmID, item.Brand, item.Status)
-		if err != nil {
+	for _, table := range []string{"deliveries", "payments", "items"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE order_uid = $1", order.OrderUID); err != nil {
 			return err
 		}
 	}
 
+	if err := insertOrderDetails(ctx, tx, order); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
-func (r *PostgresRepository) GetOrderByID(orderUID string) (*models.Order, error) {
-	ctx := context.Background()
+func (r *PostgresRepository) GetOrderByID(ctx context.Context, orderUID string) (*models.Order, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
 
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{
 		Isolation: sql.LevelRepeatableRead,
@@ -117,14 +172,16 @@ func (r *PostgresRepository) GetOrderByID(orderUID string) (*models.Order, error
 	defer tx.Rollback()
 
 	var order models.Order
-	err = r.db.QueryRowContext(ctx, `
+	err = tx.QueryRowContext(ctx, `
 		SELECT order_uid, track_number, entry, locale, internal_signature, customer_id, 
-		       delivery_service, shardkey, sm_id, date_created, oof_shard, date_created
+		       delivery_service, shardkey, sm_id, date_created, oof_shard, version, created_at,
+		       updated_at, validation_warnings
 		FROM orders WHERE order_uid = $1
 	`, orderUID).Scan(
 		&order.OrderUID, &order.TrackNumber, &order.Entry, &order.Locale,
 		&order.InternalSignature, &order.CustomerID, &order.DeliveryService,
-		&order.Shardkey, &order.SmID, &order.DateCreated, &order.OofShard, &order.CreatedAt,
+		&order.Shardkey, &order.SmID, &order.DateCreated, &order.OofShard, &order.Version, &order.CreatedAt,
+		&order.UpdatedAt, &order.Warnings,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -133,7 +190,7 @@ func (r *PostgresRepository) GetOrderByID(orderUID string) (*models.Order, error
 		return nil, err
 	}
 
-	err = r.db.QueryRowContext(ctx, `
+	err = tx.QueryRowContext(ctx, `
 		SELECT name, phone, zip, city, address, region, email
 		FROM deliveries WHERE order_uid = $1
 	`, orderUID).Scan(
@@ -144,7 +201,7 @@ func (r *PostgresRepository) GetOrderByID(orderUID string) (*models.Order, error
 		return nil, err
 	}
 
-	err = r.db.QueryRowContext(ctx, `
+	err = tx.QueryRowContext(ctx, `
 		SELECT transaction, request_id, currency, provider, amount, 
 		       payment_dt, bank, delivery_cost, goods_total, custom_fee
 		FROM payments WHERE order_uid = $1
@@ -157,7 +214,7 @@ func (r *PostgresRepository) GetOrderByID(orderUID string) (*models.Order, error
 		return nil, err
 	}
 
-	rows, err := r.db.QueryContext(ctx, `
+	rows, err := tx.QueryContext(ctx, `
 		SELECT chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status
 		FROM items WHERE order_uid = $1
 	`, orderUID)
@@ -190,54 +247,57 @@ func (r *PostgresRepository) GetOrderByID(orderUID string) (*models.Order, error
 	return &order, nil
 }
 
-func (r *PostgresRepository) LoadAllOrders() ([]*models.Order, error) {
-	ctx := context.Background()
+// StreamOrders passes every stored order matching filter to fn, newest
+// first, in chunks of up to chunkSize orders. The pagination fields of
+// filter are ignored. All chunks are read from one snapshot, and each chunk
+// is assembled with a fixed number of queries. An error returned by fn stops
+// streaming and is returned as is.
+func (r *PostgresRepository) StreamOrders(ctx context.Context, filter OrderFilter, chunkSize int, fn func([]*models.Order) error) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Stream)
+	defer cancel()
 
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{
 		Isolation: sql.LevelRepeatableRead,
 		ReadOnly:  true,
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer tx.Rollback()
 
-	rows, err := r.db.QueryContext(ctx, `
-		SELECT order_uid FROM orders ORDER BY date_created DESC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var orders []*models.Order
-	for rows.Next() {
-		var orderUID string
-		if err := rows.Scan(&orderUID); err != nil {
-			return nil, err
+	filter.AfterDate, filter.AfterUID, filter.Limit = time.Time{}, "", chunkSize
+	for {
+		query, args := buildListQuery(filter)
+		uids, err := queryOrderUIDs(ctx, tx, query, args...)
+		if err != nil {
+			return err
+		}
+		if len(uids) == 0 {
+			break
 		}
 
-		order, err := r.GetOrderByID(orderUID)
+		orders, err := loadOrders(ctx, tx, uids)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		orders = append(orders, order)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
+		if err := fn(orders); err != nil {
+			return err
+		}
 
-	if err := tx.Commit(); err != nil {
-		return nil, err
+		if len(uids) < chunkSize {
+			break
+		}
+		last := orders[len(orders)-1]
+		filter.AfterDate, filter.AfterUID = last.DateCreated, last.OrderUID
 	}
 
-	return orders, nil
+	return tx.Commit()
 }
 
-func (r *PostgresRepository) OrderExists(orderUID string) (bool, error) {
-	ctx := context.Background()
+func (r *PostgresRepository) OrderExists(ctx context.Context, orderUID string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
 
 	var exists bool
 	err := r.db.QueryRowContext(ctx, `
@@ -246,8 +306,11 @@ func (r *PostgresRepository) OrderExists(orderUID string) (bool, error) {
 	return exists, err
 }
 
-func (r *PostgresRepository) Ping() error {
-	return r.db.Ping()
+func (r *PostgresRepository) Ping(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	return r.db.PingContext(ctx)
 }
 
 func (r *PostgresRepository) Close() error {
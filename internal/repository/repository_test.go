@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"L0/internal/model"
+	"L0/migrations"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertQuery(t *testing.T) {
+	query := insertQuery("items", []string{"order_uid", "name"}, 2)
+	assert.Equal(t, "INSERT INTO items (order_uid, name) VALUES ($1, $2), ($3, $4)", query)
+}
+
+func TestChunkRows(t *testing.T) {
+	rows := make([][]any, 10000)
+	for i := range rows {
+		rows[i] = make([]any, len(itemColumns))
+	}
+
+	chunks := chunkRows(rows, len(itemColumns))
+	total := 0
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk)*len(itemColumns), maxBindParams)
+		total += len(chunk)
+	}
+	assert.Equal(t, len(rows), total)
+	assert.Len(t, chunks, 2)
+}
+
+func TestBuildListQuery_NoFilter(t *testing.T) {
+	query, args := buildListQuery(OrderFilter{})
+	assert.Equal(t, "SELECT o.order_uid FROM orders o ORDER BY o.date_created DESC, o.order_uid DESC", query)
+	assert.Empty(t, args)
+}
+
+func TestBuildListQuery_AllFilters(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	after := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	query, args := buildListQuery(OrderFilter{
+		CustomerID:      "test",
+		TrackNumber:     "WBILMTESTTRACK",
+		DeliveryService: "meest",
+		CreatedFrom:     from,
+		CreatedTo:       to,
+		Currency:        "USD",
+		Provider:        "wbpay",
+		Brand:           "Vivienne Sabo",
+		AfterDate:       after,
+		AfterUID:        "b563feb7b2b84b6test",
+		Limit:           21,
+	})
+
+	assert.Equal(t, "SELECT o.order_uid FROM orders o JOIN payments p ON p.order_uid = o.order_uid"+
+		" WHERE o.customer_id = $1 AND o.track_number = $2 AND o.delivery_service = $3"+
+		" AND o.date_created >= $4 AND o.date_created < $5 AND p.currency = $6 AND p.provider = $7"+
+		" AND EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.brand = $8)"+
+		" AND (o.date_created, o.order_uid) < ($9, $10)"+
+		" ORDER BY o.date_created DESC, o.order_uid DESC LIMIT $11", query)
+	assert.Equal(t, []any{"test", "WBILMTESTTRACK", "meest", from, to, "USD", "wbpay",
+		"Vivienne Sabo", after, "b563feb7b2b84b6test", 21}, args)
+}
+
+func TestBuildListQuery_UpdatedSince(t *testing.T) {
+	since := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	query, args := buildListQuery(OrderFilter{UpdatedSince: since, Limit: 500})
+
+	assert.Equal(t, "SELECT o.order_uid FROM orders o WHERE o.updated_at > $1"+
+		" ORDER BY o.date_created DESC, o.order_uid DESC LIMIT $2", query)
+	assert.Equal(t, []any{since, 500}, args)
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := withTimeout(context.Background(), 0)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+
+	ctx, cancel = withTimeout(context.Background(), time.Second)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+}
+
+// testRepository connects to the database given by TEST_DATABASE_DSN and
+// migrates it, or skips the test if it is not set. CI runs the tests
+// against a Postgres service container, and make test-db against the
+// docker-compose database.
+func testRepository(t *testing.T) *PostgresRepository {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	repo, err := New(dsn, Timeouts{})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	require.NoError(t, migrations.Apply(repo.DB()))
+	return repo
+}
+
+// TestMoney_PostgresRoundTrip stores orders decoded from JSON and checks
+// that every amount reads back unchanged.
+func TestMoney_PostgresRoundTrip(t *testing.T) {
+	repo := testRepository(t)
+
+	ctx := context.Background()
+	for i, amount := range []string{"0.1", "0.125", "0.1234", "317.1", "1817", "999999999999.9999"} {
+		uid := fmt.Sprintf("money-round-trip-%d-%d", time.Now().UnixNano(), i)
+		payload := fmt.Sprintf(`{"order_uid": %q,
+			"payment": {"amount": %[2]s, "delivery_cost": %[2]s, "goods_total": %[2]s, "custom_fee": %[2]s},
+			"items": [{"price": %[2]s, "total_price": %[2]s}]}`, uid, amount)
+
+		var order models.Order
+		require.NoError(t, json.Unmarshal([]byte(payload), &order))
+		require.NoError(t, repo.SaveOrder(ctx, &order))
+		t.Cleanup(func() { repo.DB().Exec("DELETE FROM orders WHERE order_uid = $1", uid) })
+
+		stored, err := repo.GetOrderByID(ctx, uid)
+		require.NoError(t, err)
+		require.NotNil(t, stored)
+		require.Len(t, stored.Items, 1)
+
+		p, item := stored.Payment, stored.Items[0]
+		for _, money := range []models.Money{p.Amount, p.DeliveryCost, p.GoodsTotal, p.CustomFee, item.Price, item.TotalPrice} {
+			data, err := json.Marshal(money)
+			require.NoError(t, err)
+			assert.Equal(t, amount, string(data))
+		}
+	}
+}
+
+// TestSaveOrders_DuplicateInBatch checks that only the first copy of an
+// order repeated in a batch is inserted. The later copies get
+// ErrOrderExists, so that the caller applies them as updates.
+func TestSaveOrders_DuplicateInBatch(t *testing.T) {
+	repo := testRepository(t)
+
+	uid := fmt.Sprintf("duplicate-in-batch-%d", time.Now().UnixNano())
+	t.Cleanup(func() { repo.DB().Exec("DELETE FROM orders WHERE order_uid = $1", uid) })
+	orders := []*models.Order{
+		{OrderUID: uid, TrackNumber: "FIRST", Version: 1},
+		{OrderUID: uid, TrackNumber: "SECOND", Version: 2},
+	}
+
+	errs, err := repo.SaveOrders(context.Background(), orders)
+	require.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], ErrOrderExists)
+
+	require.NoError(t, repo.UpdateOrder(context.Background(), orders[1]))
+	stored, err := repo.GetOrderByID(context.Background(), uid)
+	require.NoError(t, err)
+	assert.Equal(t, "SECOND", stored.TrackNumber)
+	assert.Equal(t, 2, stored.Version)
+}
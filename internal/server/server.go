@@ -0,0 +1,56 @@
+package server
+
+import (
+	"L0/internal/health"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ShutdownOptions control how Serve stops.
+type ShutdownOptions struct {
+	// Delay is how long the probe reports not ready before the server stops
+	// accepting connections, so that load balancers stop sending traffic.
+	Delay time.Duration
+	// Timeout bounds how long in-flight requests may take to complete.
+	Timeout time.Duration
+}
+
+// Serve serves srv on ln until ctx is done and then shuts it down
+// gracefully. It returns once all in-flight requests have completed, or
+// with an error when the server failed or they did not complete in time.
+func Serve(ctx context.Context, srv *http.Server, ln net.Listener, probe *health.Probe, opts ShutdownOptions) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("error serving HTTP: %v", err)
+	case <-ctx.Done():
+	}
+
+	probe.Drain()
+	select {
+	case <-time.After(opts.Delay):
+	case err := <-errs:
+		return fmt.Errorf("error serving HTTP: %v", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("error shutting down HTTP server: %v", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving HTTP: %v", err)
+	}
+
+	return nil
+}
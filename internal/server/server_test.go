@@ -0,0 +1,113 @@
+package server
+
+import (
+	"L0/internal/health"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePinger struct{}
+
+func (fakePinger) Ping(context.Context) error { return nil }
+
+// startServer serves a slow endpoint, which waits for release, and the
+// readiness probe on a random port.
+func startServer(t *testing.T, opts ShutdownOptions) (string, *health.Probe, chan struct{}, chan struct{}, context.CancelFunc, chan error) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	probe := health.NewProbe(fakePinger{})
+	probe.SetReady(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	mux.HandleFunc("/health/ready", probe.ReadyHandler())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, &http.Server{Handler: mux}, ln, probe, opts)
+	}()
+
+	return "http://" + ln.Addr().String(), probe, started, release, cancel, served
+}
+
+func TestServe_CompletesInFlightRequest(t *testing.T) {
+	url, probe, started, release, cancel, served := startServer(t, ShutdownOptions{
+		Delay:   100 * time.Millisecond,
+		Timeout: 5 * time.Second,
+	})
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	// The server keeps serving while it reports not ready.
+	require.Eventually(t, func() bool { return !probe.Ready() }, time.Second, 5*time.Millisecond)
+	resp, err := http.Get(url + "/health/ready")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// The in-flight request holds up the shutdown until it completes.
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case err := <-served:
+		t.Fatalf("server stopped with a request in flight: %v", err)
+	default:
+	}
+
+	close(release)
+	r := <-responses
+	require.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+	assert.NoError(t, <-served)
+
+	_, err = http.Get(url + "/health/ready")
+	assert.Error(t, err)
+}
+
+func TestServe_DrainTimeout(t *testing.T) {
+	url, _, started, release, cancel, served := startServer(t, ShutdownOptions{Timeout: 50 * time.Millisecond})
+	defer close(release)
+
+	go http.Get(url + "/slow")
+	<-started
+	cancel()
+
+	select {
+	case err := <-served:
+		assert.ErrorContains(t, err, "error shutting down HTTP server")
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not time out")
+	}
+}
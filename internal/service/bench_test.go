@@ -2,27 +2,52 @@ package service
 
 import (
 	models "L0/internal/model"
+	"L0/internal/repository"
+	"context"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/goccy/go-json"
+
 	"L0/internal/cache"
 )
 
 type mockRepo struct{}
 
-func (m *mockRepo) SaveOrder(*models.Order) error              { return nil }
-func (m *mockRepo) GetOrderByID(string) (*models.Order, error) { return nil, nil }
-func (m *mockRepo) LoadAllOrders() ([]*models.Order, error)    { return nil, nil }
-func (m *mockRepo) OrderExists(string) (bool, error)           { return false, nil }
-func (m *mockRepo) Ping() error                                { return nil }
-func (m *mockRepo) Close() error                               { return nil }
+func (m *mockRepo) SaveOrder(context.Context, *models.Order) error { return nil }
+func (m *mockRepo) SaveOrders(ctx context.Context, orders []*models.Order) ([]error, error) {
+	return make([]error, len(orders)), nil
+}
+func (m *mockRepo) UpdateOrder(context.Context, *models.Order) error            { return nil }
+func (m *mockRepo) GetOrderByID(context.Context, string) (*models.Order, error) { return nil, nil }
+func (m *mockRepo) GetOrdersByIDs(context.Context, []string) ([]*models.Order, error) {
+	return nil, nil
+}
+func (m *mockRepo) StreamOrders(context.Context, repository.OrderFilter, int, func([]*models.Order) error) error {
+	return nil
+}
+func (m *mockRepo) ListOrders(context.Context, repository.OrderFilter) ([]*models.Order, error) {
+	return nil, nil
+}
+func (m *mockRepo) FindOrdersByTrackNumber(context.Context, string) ([]*models.Order, error) {
+	return nil, nil
+}
+func (m *mockRepo) FindOrdersByItemTrackNumber(context.Context, string) ([]*models.Order, error) {
+	return nil, nil
+}
+func (m *mockRepo) FindOrdersByChrtID(context.Context, int) ([]*models.Order, error) { return nil, nil }
+func (m *mockRepo) FindOrdersByNmID(context.Context, int) ([]*models.Order, error)   { return nil, nil }
+func (m *mockRepo) OrderExists(context.Context, string) (bool, error)                { return false, nil }
+func (m *mockRepo) Ping(context.Context) error                                       { return nil }
+func (m *mockRepo) Close() error                                                     { return nil }
 
 func generateValidOrder() *models.Order {
 	return &models.Order{
 		OrderUID:        "bench-uid",
 		TrackNumber:     "TRACK123",
 		Entry:           "entry",
-		Locale:          "en_US",
+		Locale:          "en",
 		CustomerID:      "cust-1",
 		DeliveryService: "DHL",
 		DateCreated:     time.Now(),
@@ -33,12 +58,12 @@ func generateValidOrder() *models.Order {
 		},
 		Payment: models.Payment{
 			Transaction: "txn-1", Currency: "USD", Provider: "payme",
-			Amount: 100.0, PaymentDT: 1234567890, Bank: "bank",
-			DeliveryCost: 10.0, GoodsTotal: 90.0, CustomFee: 0,
+			Amount: models.MoneyFromFloat(60), PaymentDT: 1234567890, Bank: "bank",
+			DeliveryCost: models.MoneyFromFloat(10), GoodsTotal: models.MoneyFromFloat(50), CustomFee: 0,
 		},
 		Items: []models.Item{
-			{ChrtID: 1, TrackNumber: "T1", Price: 50.0, Rid: "r1",
-				Name: "Item", Sale: 0, Size: "M", TotalPrice: 50.0,
+			{ChrtID: 1, TrackNumber: "T1", Price: models.MoneyFromFloat(50), Rid: "r1",
+				Name: "Item", Sale: 0, Size: "M", TotalPrice: models.MoneyFromFloat(50),
 				NmID: 1, Brand: "A", Status: 1},
 		},
 	}
@@ -58,6 +83,40 @@ func BenchmarkCreateOrder(b *testing.B) {
 	order := generateValidOrder()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = svc.CreateOrder(order)
+		_ = svc.CreateOrder(context.Background(), order)
+	}
+}
+
+func BenchmarkProcessOrderMessage(b *testing.B) {
+	svc := NewOrderService(&mockRepo{}, cache.New(1e6, 10*time.Minute))
+	messages := generateOrderMessages(b, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, message := range messages {
+			_ = svc.ProcessOrderMessage(context.Background(), message)
+		}
+	}
+}
+
+func BenchmarkProcessOrderMessages(b *testing.B) {
+	svc := NewOrderService(&mockRepo{}, cache.New(1e6, 10*time.Minute))
+	messages := generateOrderMessages(b, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = svc.ProcessOrderMessages(context.Background(), messages)
+	}
+}
+
+func generateOrderMessages(b *testing.B, n int) [][]byte {
+	messages := make([][]byte, n)
+	for i := range messages {
+		order := generateValidOrder()
+		order.OrderUID = "bench-uid-" + strconv.Itoa(i)
+		message, err := json.Marshal(order)
+		if err != nil {
+			b.Fatal(err)
+		}
+		messages[i] = message
 	}
+	return messages
 }
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"L0/internal/metrics"
+	models "L0/internal/model"
+	"fmt"
+	"strings"
+)
+
+// Severities of a consistency rule.
+const (
+	// SeverityReject fails validation of an order breaking the rule.
+	SeverityReject = "reject"
+	// SeverityWarn accepts the order and records a warning on it.
+	SeverityWarn = "warn"
+)
+
+// Names of the consistency rules.
+const (
+	// RuleGoodsTotal checks that payment.goods_total is the sum of the item
+	// total prices.
+	RuleGoodsTotal = "goods_total"
+	// RuleAmount checks that payment.amount is goods_total plus
+	// delivery_cost plus custom_fee.
+	RuleAmount = "amount"
+	// RuleItemTotal checks that the total price of every item is its price
+	// less the sale percent.
+	RuleItemTotal = "item_total_price"
+)
+
+// ConsistencyRule configures a check that the amounts of an order add up.
+type ConsistencyRule struct {
+	Severity string
+	// Tolerance is the largest difference accepted, to allow for rounding.
+	Tolerance models.Money
+}
+
+// ConsistencyRules maps rule names to their configuration. Rules missing
+// from the map are not checked.
+type ConsistencyRules map[string]ConsistencyRule
+
+// DefaultConsistencyRules rejects orders whose totals do not add up. Item
+// total prices are often rounded to whole units, so a mismatch of up to one
+// unit is accepted and a larger one only recorded.
+func DefaultConsistencyRules() ConsistencyRules {
+	return ConsistencyRules{
+		RuleGoodsTotal: {Severity: SeverityReject, Tolerance: models.MoneyFromFloat(0.01)},
+		RuleAmount:     {Severity: SeverityReject, Tolerance: models.MoneyFromFloat(0.01)},
+		RuleItemTotal:  {Severity: SeverityWarn, Tolerance: models.MoneyFromFloat(1)},
+	}
+}
+
+// ParseConsistencyRules parses a comma-separated list of
+// name:severity:tolerance entries, such as "amount:reject:0.01".
+func ParseConsistencyRules(value string) (ConsistencyRules, error) {
+	rules := make(ConsistencyRules)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid consistency rule %q: want name:severity:tolerance", entry)
+		}
+		name, severity := parts[0], parts[1]
+
+		switch name {
+		case RuleGoodsTotal, RuleAmount, RuleItemTotal:
+		default:
+			return nil, fmt.Errorf("unknown consistency rule %q", name)
+		}
+		if severity != SeverityReject && severity != SeverityWarn {
+			return nil, fmt.Errorf("invalid severity %q of consistency rule %s", severity, name)
+		}
+		tolerance, err := models.ParseMoney(parts[2])
+		if err != nil || tolerance < 0 {
+			return nil, fmt.Errorf("invalid tolerance %q of consistency rule %s", parts[2], name)
+		}
+
+		rules[name] = ConsistencyRule{Severity: severity, Tolerance: tolerance}
+	}
+	return rules, nil
+}
+
+// WithConsistencyRules replaces the default consistency rules.
+func (s *OrderService) WithConsistencyRules(rules ConsistencyRules) *OrderService {
+	s.rules = rules
+	return s
+}
+
+// checkConsistency runs the consistency rules on an order whose fields are
+// valid on their own. Broken reject rules are added to v; broken warn rules
+// are recorded on the order.
+func (s *OrderService) checkConsistency(order *models.Order, v *validator) {
+	check := func(name, field string, got, want models.Money, message string) {
+		rule, ok := s.rules[name]
+		if diff := got - want; !ok || diff <= rule.Tolerance && -diff <= rule.Tolerance {
+			return
+		}
+
+		if rule.Severity == SeverityWarn {
+			order.Warnings = append(order.Warnings, models.Warning{Rule: name, Field: field, Message: message})
+			metrics.ValidationWarnings.WithLabelValues(name).Inc()
+			return
+		}
+		v.add(field, CodeMismatch, message)
+	}
+
+	p := order.Payment
+	var goodsTotal models.Money
+	for i, item := range order.Items {
+		goodsTotal += item.TotalPrice
+
+		want := item.Price.Percent(100 - item.Sale)
+		check(RuleItemTotal, fmt.Sprintf("items[%d].total_price", i), item.TotalPrice, want,
+			fmt.Sprintf("must be price less sale (%s)", want))
+	}
+
+	check(RuleGoodsTotal, "payment.goods_total", p.GoodsTotal, goodsTotal,
+		fmt.Sprintf("must equal the sum of item total prices (%s)", goodsTotal))
+
+	amount := p.GoodsTotal + p.DeliveryCost + p.CustomFee
+	check(RuleAmount, "payment.amount", p.Amount, amount,
+		fmt.Sprintf("must equal goods_total + delivery_cost + custom_fee (%s)", amount))
+}
@@ -0,0 +1,25 @@
+package service
+
+import "errors"
+
+var (
+	// ErrStorage marks failures of the underlying storage. Such errors are
+	// usually transient, so callers may retry the operation.
+	ErrStorage = errors.New("storage error")
+
+	ErrOrderExists  = errors.New("order already exists")
+	ErrNotFound     = errors.New("order not found")
+	ErrStaleVersion = errors.New("stale order version")
+)
+
+// IsTransient reports whether err is worth retrying.
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrStorage)
+}
+
+// IsAlreadyApplied reports whether err means the order is already stored
+// in the same or a newer version, which is expected when a message is
+// delivered more than once.
+func IsAlreadyApplied(err error) bool {
+	return errors.Is(err, ErrOrderExists) || errors.Is(err, ErrStaleVersion)
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"L0/internal/metrics"
+	models "L0/internal/model"
+	"L0/internal/repository"
+	"context"
+	"fmt"
+	"time"
+)
+
+// CacheOrders caches orders created by any process. Orders already cached
+// with the notified version, such as the ones this process created, are not
+// loaded again.
+func (s *OrderService) CacheOrders(ctx context.Context, versions map[string]int) error {
+	orders, err := s.loadChangedOrders(ctx, versions)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		if version, ok := s.cache.Version(order.OrderUID); !ok || version <= order.Version {
+			s.cache.Set(order)
+		}
+	}
+	return nil
+}
+
+// RefreshOrders reloads cached orders updated by any process, see
+// cache.Cache.Refresh.
+func (s *OrderService) RefreshOrders(ctx context.Context, versions map[string]int) error {
+	orders, err := s.loadChangedOrders(ctx, versions)
+	if err != nil {
+		return err
+	}
+
+	s.cache.Refresh(orders)
+	return nil
+}
+
+// EvictOrders drops orders deleted by any process.
+func (s *OrderService) EvictOrders(uids []string) {
+	for _, uid := range uids {
+		s.cache.Remove(uid)
+	}
+}
+
+func (s *OrderService) ClearCache() {
+	s.cache.Clear()
+}
+
+// ReconcileCache refreshes the cache with the orders changed in the
+// database since a time. If that fails, entries may be stale, so none of
+// them are kept.
+func (s *OrderService) ReconcileCache(ctx context.Context, since time.Time) error {
+	filter := repository.OrderFilter{UpdatedSince: since}
+	err := s.repo.StreamOrders(ctx, filter, warmUpChunkSize, func(orders []*models.Order) error {
+		s.cache.Refresh(orders)
+		return nil
+	})
+	if err != nil {
+		s.cache.Clear()
+		return fmt.Errorf("error reconciling cache: %v", err)
+	}
+
+	return nil
+}
+
+// loadChangedOrders loads the orders of versions the cache does not have
+// yet. Orders that no longer exist are dropped from the cache. If loading
+// fails, all of them are invalidated rather than served stale until they
+// expire.
+func (s *OrderService) loadChangedOrders(ctx context.Context, versions map[string]int) ([]*models.Order, error) {
+	uids := make([]string, 0, len(versions))
+	for uid, version := range versions {
+		if cached, ok := s.cache.Version(uid); ok && cached >= version {
+			continue
+		}
+		uids = append(uids, uid)
+	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	orders, err := s.repo.GetOrdersByIDs(ctx, uids)
+	if err != nil {
+		metrics.DBErrors.Inc()
+		s.cache.Invalidate(uids)
+		return nil, fmt.Errorf("error loading changed orders: %w: %v", ErrStorage, err)
+	}
+
+	found := make(map[string]bool, len(orders))
+	for _, order := range orders {
+		found[order.OrderUID] = true
+	}
+	for _, uid := range uids {
+		if !found[uid] {
+			s.cache.Remove(uid)
+		}
+	}
+
+	return orders, nil
+}
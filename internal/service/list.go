@@ -0,0 +1,91 @@
+package service
+
+import (
+	"L0/internal/metrics"
+	models "L0/internal/model"
+	"L0/internal/repository"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// OrderPage is one page of ListOrders. NextCursor is empty on the last page.
+type OrderPage struct {
+	Orders     []*models.Order `json:"orders"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// ListOrders returns orders matching filter, newest first. The page starts
+// after cursor, which is taken from a previous OrderPage; an empty cursor
+// starts from the newest order. The pagination fields of filter are set
+// from cursor and limit.
+func (s *OrderService) ListOrders(ctx context.Context, filter repository.OrderFilter, cursor string, limit int) (*OrderPage, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	filter.AfterDate, filter.AfterUID = time.Time{}, ""
+	if cursor != "" {
+		after, uid, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, invalid("cursor", CodeInvalidFormat, err.Error())
+		}
+		filter.AfterDate, filter.AfterUID = after, uid
+	}
+
+	// One extra order tells whether there is a next page.
+	filter.Limit = limit + 1
+
+	orders, err := s.repo.ListOrders(ctx, filter)
+	if err != nil {
+		metrics.DBErrors.Inc()
+		return nil, fmt.Errorf("error listing orders: %w: %v", ErrStorage, err)
+	}
+
+	page := &OrderPage{Orders: orders}
+	if len(orders) > limit {
+		page.Orders = orders[:limit]
+		last := page.Orders[limit-1]
+		page.NextCursor = encodeCursor(last.DateCreated, last.OrderUID)
+	}
+	if page.Orders == nil {
+		page.Orders = []*models.Order{}
+	}
+
+	return page, nil
+}
+
+func encodeCursor(dateCreated time.Time, orderUID string) string {
+	raw := dateCreated.UTC().Format(time.RFC3339Nano) + "|" + orderUID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", errors.New("is not a cursor returned by a previous page")
+	}
+
+	date, uid, ok := strings.Cut(string(raw), "|")
+	if !ok || uid == "" {
+		return time.Time{}, "", errors.New("is not a cursor returned by a previous page")
+	}
+
+	dateCreated, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return time.Time{}, "", errors.New("is not a cursor returned by a previous page")
+	}
+
+	return dateCreated, uid, nil
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"L0/internal/cache"
+	"L0/internal/metrics"
+	models "L0/internal/model"
+	"context"
+	"fmt"
+)
+
+// GetOrdersByTrackNumber returns all orders with the given order track number.
+func (s *OrderService) GetOrdersByTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error) {
+	return s.lookup(cache.ByTrackNumber, trackNumber, func() ([]*models.Order, error) {
+		return s.repo.FindOrdersByTrackNumber(ctx, trackNumber)
+	})
+}
+
+// GetOrdersByItemTrackNumber returns all orders having an item with the given track number.
+func (s *OrderService) GetOrdersByItemTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error) {
+	return s.lookup(cache.ByItemTrackNumber, trackNumber, func() ([]*models.Order, error) {
+		return s.repo.FindOrdersByItemTrackNumber(ctx, trackNumber)
+	})
+}
+
+// GetOrdersByChrtID returns all orders having an item with the given chrt_id.
+func (s *OrderService) GetOrdersByChrtID(ctx context.Context, chrtID int) ([]*models.Order, error) {
+	return s.lookup(cache.ByChrtID, cache.IntKey(chrtID), func() ([]*models.Order, error) {
+		return s.repo.FindOrdersByChrtID(ctx, chrtID)
+	})
+}
+
+// GetOrdersByNmID returns all orders having an item with the given nm_id.
+func (s *OrderService) GetOrdersByNmID(ctx context.Context, nmID int) ([]*models.Order, error) {
+	return s.lookup(cache.ByNmID, cache.IntKey(nmID), func() ([]*models.Order, error) {
+		return s.repo.FindOrdersByNmID(ctx, nmID)
+	})
+}
+
+func (s *OrderService) lookup(kind cache.IndexKind, value string, load func() ([]*models.Order, error)) ([]*models.Order, error) {
+	if orders, ok := s.cache.Lookup(kind, value); ok {
+		metrics.OrdersFromCache.Add(float64(len(orders)))
+		return orders, nil
+	}
+
+	orders, err := load()
+	if err != nil {
+		metrics.DBErrors.Inc()
+		return nil, fmt.Errorf("error looking up orders in DB: %w: %v", ErrStorage, err)
+	}
+
+	s.cache.SetLookup(kind, value, orders)
+	metrics.OrdersFromDB.Add(float64(len(orders)))
+
+	if orders == nil {
+		orders = []*models.Order{}
+	}
+	return orders, nil
+}
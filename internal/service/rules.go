@@ -0,0 +1,280 @@
+package service
+
+import (
+	"L0/configs"
+	"L0/internal/config"
+	"L0/internal/iso"
+	models "L0/internal/model"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// itemsPrefix starts the paths of rules applied to every item.
+const itemsPrefix = "items[]."
+
+// stringFormat is a standard a string value must follow.
+type stringFormat struct {
+	valid   func(string) bool
+	message string
+}
+
+// formats are the values of the format option of a rule.
+var formats = map[string]*stringFormat{
+	"iso4217": {valid: iso.IsCurrency, message: "is not an ISO 4217 currency code"},
+	"bcp47":   {valid: iso.IsLanguageTag, message: "is not a BCP 47 language tag"},
+}
+
+// FieldRules is a compiled set of field validation rules.
+type FieldRules struct {
+	// order holds the rules on the order, its delivery and payment, and
+	// items the rules applied to every item.
+	order []fieldRule
+	items []fieldRule
+}
+
+type fieldRule struct {
+	// path is the JSON path of the value, relative to the item for item
+	// rules, and index the path of struct fields leading to it.
+	path  string
+	index []int
+	kind  reflect.Kind
+	// money is set for models.Money fields, whose bounds are in units
+	// rather than in the ten-thousandths they are stored as.
+	money bool
+
+	required     bool
+	maxLength    int
+	format       *stringFormat
+	pattern      *regexp.Regexp
+	enum         map[string]bool
+	enumList     string
+	min, max     *float64
+	minExclusive bool
+}
+
+// CompileFieldRules checks that every rule names a field of the order and
+// fits its type, and prepares the rules for validation.
+func CompileFieldRules(rules *config.ValidationRules) (*FieldRules, error) {
+	compiled := &FieldRules{}
+	for _, rule := range rules.Fields {
+		root, path, items := reflect.TypeOf(models.Order{}), rule.Path, false
+		if strings.HasPrefix(path, itemsPrefix) {
+			root, path, items = reflect.TypeOf(models.Item{}), strings.TrimPrefix(path, itemsPrefix), true
+		}
+
+		r, err := compileFieldRule(root, path, rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule for %s: %v", rule.Path, err)
+		}
+
+		if items {
+			compiled.items = append(compiled.items, r)
+		} else {
+			compiled.order = append(compiled.order, r)
+		}
+	}
+	return compiled, nil
+}
+
+func compileFieldRule(root reflect.Type, path string, rule config.FieldRule) (fieldRule, error) {
+	r := fieldRule{
+		path:         path,
+		required:     rule.Required,
+		maxLength:    rule.MaxLength,
+		min:          rule.Min,
+		max:          rule.Max,
+		minExclusive: rule.MinExclusive,
+	}
+
+	t := root
+	for _, name := range strings.Split(path, ".") {
+		if t.Kind() != reflect.Struct {
+			return r, fmt.Errorf("%s is not an object", name)
+		}
+		field, ok := fieldByJSONName(t, name)
+		if !ok {
+			return r, fmt.Errorf("unknown field %s", name)
+		}
+		r.index = append(r.index, field.Index...)
+		t = field.Type
+	}
+	r.kind = t.Kind()
+	r.money = t == reflect.TypeOf(models.Money(0))
+
+	isString := r.kind == reflect.String
+	switch r.kind {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Float64:
+	default:
+		return r, fmt.Errorf("unsupported field type %s", t)
+	}
+
+	if !isString && (rule.MaxLength > 0 || rule.Format != "" || rule.Pattern != "" || len(rule.Enum) > 0) {
+		return r, fmt.Errorf("max_length, format, pattern and enum apply to strings only")
+	}
+	if isString && (rule.Min != nil || rule.Max != nil) {
+		return r, fmt.Errorf("min and max apply to numbers only")
+	}
+
+	if rule.Format != "" {
+		format, ok := formats[rule.Format]
+		if !ok {
+			return r, fmt.Errorf("unknown format %s", rule.Format)
+		}
+		r.format = format
+	}
+	if rule.Pattern != "" {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return r, err
+		}
+		r.pattern = pattern
+	}
+	if len(rule.Enum) > 0 {
+		r.enum = make(map[string]bool, len(rule.Enum))
+		for _, v := range rule.Enum {
+			r.enum[v] = true
+		}
+		r.enumList = strings.Join(rule.Enum, ", ")
+	}
+
+	return r, nil
+}
+
+func fieldByJSONName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// DefaultFieldRules compiles the rules shipped in configs/validation.json.
+func DefaultFieldRules() *FieldRules {
+	rules, err := config.ParseValidationRules(configs.Validation)
+	if err == nil {
+		var compiled *FieldRules
+		if compiled, err = CompileFieldRules(rules); err == nil {
+			return compiled
+		}
+	}
+	panic("invalid default validation rules: " + err.Error())
+}
+
+// check validates the value of the rule in v, the order or item the rule
+// applies to. prefix is prepended to the path in reported violations.
+func (r *fieldRule) check(v reflect.Value, prefix string, errs *validator) {
+	field := prefix + r.path
+	value := v.FieldByIndex(r.index)
+
+	if r.kind == reflect.String {
+		s := value.String()
+		switch {
+		case s == "":
+			if r.required {
+				errs.add(field, CodeRequired, "is required")
+			}
+		case r.maxLength > 0 && utf8.RuneCountInString(s) > r.maxLength:
+			errs.add(field, CodeTooLong, fmt.Sprintf("must be less than %d characters", r.maxLength))
+		case r.format != nil && !r.format.valid(s):
+			errs.add(field, CodeInvalidFormat, r.format.message)
+		case r.pattern != nil && !r.pattern.MatchString(s):
+			errs.add(field, CodeInvalidFormat, "format is invalid")
+		case r.enum != nil && !r.enum[s]:
+			errs.add(field, CodeNotAllowed, "must be one of "+r.enumList)
+		}
+		return
+	}
+
+	var x float64
+	switch {
+	case r.money:
+		x = models.Money(value.Int()).Float64()
+	case r.kind == reflect.Float64:
+		x = value.Float()
+	default:
+		x = float64(value.Int())
+	}
+
+	switch {
+	case x == 0 && r.required:
+		errs.add(field, CodeRequired, "is required")
+	case r.min != nil && r.minExclusive && x <= *r.min:
+		if *r.min == 0 {
+			errs.add(field, CodeNotPositive, "must be positive")
+		} else {
+			errs.add(field, CodeOutOfRange, "must be greater than "+formatBound(*r.min))
+		}
+	case r.min != nil && !r.minExclusive && x < *r.min:
+		if *r.min == 0 {
+			errs.add(field, CodeNegative, "cannot be negative")
+		} else {
+			errs.add(field, CodeOutOfRange, "must be at least "+formatBound(*r.min))
+		}
+	case r.max != nil && x > *r.max:
+		errs.add(field, CodeOutOfRange, "must be at most "+formatBound(*r.max))
+	}
+}
+
+// formatBound writes a bound in full, as a limit such as 1e+12 reads badly
+// in an error message.
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'f', -1, 64)
+}
+
+// WithFieldRules replaces the field validation rules.
+func (s *OrderService) WithFieldRules(rules *FieldRules) *OrderService {
+	s.fields.Store(rules)
+	return s
+}
+
+// WatchFieldRules reloads the field validation rules from the file at path
+// whenever its modification time changes, checking every interval until ctx
+// is done. The outcome of every reload is passed to onReload; rules that
+// fail to load or compile leave the current rules in place.
+func (s *OrderService) WatchFieldRules(ctx context.Context, path string, interval time.Duration, onReload func(error)) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+
+			onReload(s.reloadFieldRules(path))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *OrderService) reloadFieldRules(path string) error {
+	rules, err := config.LoadValidationRules(path)
+	if err != nil {
+		return err
+	}
+	compiled, err := CompileFieldRules(rules)
+	if err != nil {
+		return err
+	}
+	s.fields.Store(compiled)
+	return nil
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"L0/configs"
+	"L0/internal/config"
+	"L0/internal/model"
+	"L0/migrations"
+	"context"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createTableRe = regexp.MustCompile(`(?is)CREATE TABLE IF NOT EXISTS (\w+)\s*\((.*?)\n\s*\);`)
+	columnRe      = regexp.MustCompile(`(?im)^\s*(\w+)\s+(VARCHAR|DECIMAL|NUMERIC)\((\d+)(?:,\s*(\d+))?\)`)
+	alterTableRe  = regexp.MustCompile(`(?is)ALTER TABLE (\w+)\s+(.*?);`)
+	alterColumnRe = regexp.MustCompile(`(?i)(?:ADD COLUMN(?: IF NOT EXISTS)?|ALTER COLUMN) (\w+) (?:TYPE )?(VARCHAR|DECIMAL|NUMERIC)\((\d+)(?:,\s*(\d+))?\)`)
+)
+
+// columnType is a sized column type, such as VARCHAR(255) or
+// NUMERIC(19,4).
+type columnType struct {
+	name        string
+	size, scale int
+}
+
+// columnTypes returns the sized columns created or altered by the
+// migrations, by table and column.
+func columnTypes(t *testing.T) map[string]map[string]columnType {
+	columns := make(map[string]map[string]columnType)
+	set := func(table string, match []string) {
+		size, err := strconv.Atoi(match[3])
+		require.NoError(t, err)
+		scale := 0
+		if match[4] != "" {
+			scale, err = strconv.Atoi(match[4])
+			require.NoError(t, err)
+		}
+		if columns[table] == nil {
+			columns[table] = make(map[string]columnType)
+		}
+		name := strings.ToUpper(match[2])
+		if name == "DECIMAL" {
+			name = "NUMERIC"
+		}
+		columns[table][match[1]] = columnType{name: name, size: size, scale: scale}
+	}
+
+	files, err := fs.Glob(migrations.EmbedMigrations, "*.sql")
+	require.NoError(t, err)
+	for _, file := range files {
+		data, err := fs.ReadFile(migrations.EmbedMigrations, file)
+		require.NoError(t, err)
+		up, _, _ := strings.Cut(string(data), "-- +goose Down")
+
+		for _, table := range createTableRe.FindAllStringSubmatch(up, -1) {
+			for _, column := range columnRe.FindAllStringSubmatch(table[2], -1) {
+				set(table[1], column)
+			}
+		}
+		for _, table := range alterTableRe.FindAllStringSubmatch(up, -1) {
+			for _, column := range alterColumnRe.FindAllStringSubmatch(table[2], -1) {
+				set(table[1], column)
+			}
+		}
+	}
+	return columns
+}
+
+// schemaFields calls fn with the rule path and model field of every sized
+// column the migrations create for a field of the order.
+func schemaFields(t *testing.T, fn func(path string, field reflect.StructField, column columnType)) {
+	tables := map[string]struct {
+		model  reflect.Type
+		prefix string
+	}{
+		"orders":     {reflect.TypeOf(models.Order{}), ""},
+		"deliveries": {reflect.TypeOf(models.Delivery{}), "delivery."},
+		"payments":   {reflect.TypeOf(models.Payment{}), "payment."},
+		"items":      {reflect.TypeOf(models.Item{}), "items[]."},
+	}
+
+	columns := columnTypes(t)
+	require.NotEmpty(t, columns["orders"])
+
+	for table, types := range columns {
+		model, ok := tables[table]
+		require.True(t, ok, "table %s has no model", table)
+
+		for column, columnType := range types {
+			// Columns without a JSON field, such as the order_uid of the
+			// delivery, are filled in by the repository.
+			field, ok := fieldByJSONName(model.model, column)
+			if !ok {
+				continue
+			}
+			fn(model.prefix+column, field, columnType)
+		}
+	}
+}
+
+func defaultRules(t *testing.T) map[string]config.FieldRule {
+	rules, err := config.ParseValidationRules(configs.Validation)
+	require.NoError(t, err)
+
+	byPath := make(map[string]config.FieldRule)
+	for _, rule := range rules.Fields {
+		byPath[rule.Path] = rule
+	}
+	return byPath
+}
+
+func TestDefaultFieldRules_MatchSchema(t *testing.T) {
+	rules := defaultRules(t)
+
+	schemaFields(t, func(path string, field reflect.StructField, column columnType) {
+		if column.name != "VARCHAR" {
+			return
+		}
+		rule, ok := rules[path]
+		if assert.True(t, ok, "no rule for %s VARCHAR(%d)", path, column.size) {
+			assert.True(t, rule.MaxLength > 0 && rule.MaxLength <= column.size,
+				"max_length of %s is %d, column is VARCHAR(%d)", path, rule.MaxLength, column.size)
+		}
+	})
+}
+
+// TestMoneyColumns_MatchSchema checks that money columns keep every digit
+// of models.Money and that the rules reject amounts too large for them.
+func TestMoneyColumns_MatchSchema(t *testing.T) {
+	rules := defaultRules(t)
+	moneyType := reflect.TypeOf(models.Money(0))
+
+	var found int
+	schemaFields(t, func(path string, field reflect.StructField, column columnType) {
+		if column.name != "NUMERIC" {
+			return
+		}
+		found++
+		assert.Equal(t, moneyType, field.Type, path)
+		assert.Equal(t, models.MoneyDigits, column.scale, "%s is NUMERIC(%d,%d)", path, column.size, column.scale)
+
+		limit := math.Pow10(column.size - column.scale)
+		rule := rules[path]
+		if assert.NotNil(t, rule.Max, "no max for %s", path) {
+			assert.Less(t, *rule.Max, limit, "max of %s does not fit NUMERIC(%d,%d)", path, column.size, column.scale)
+		}
+	})
+	assert.Equal(t, 6, found)
+}
+
+func TestCompileFieldRules(t *testing.T) {
+	zero := 0.0
+
+	for _, rule := range []config.FieldRule{
+		{Path: "unknown", Required: true},
+		{Path: "delivery.unknown", Required: true},
+		{Path: "order_uid.length", Required: true},
+		{Path: "delivery", Required: true},
+		{Path: "items[].unknown", Required: true},
+		{Path: "payment.amount", MaxLength: 10},
+		{Path: "items[].sale", Enum: []string{"10"}},
+		{Path: "locale", Min: &zero},
+		{Path: "locale", Pattern: "("},
+		{Path: "locale", Format: "iso639"},
+		{Path: "sm_id", Format: "iso4217"},
+	} {
+		_, err := CompileFieldRules(&config.ValidationRules{Fields: []config.FieldRule{rule}})
+		assert.Error(t, err, rule.Path)
+	}
+
+	_, err := config.ParseValidationRules([]byte(`{"fields": [{"path": "locale", "maxlength": 10}]}`))
+	assert.Error(t, err)
+}
+
+func TestFieldRules_Check(t *testing.T) {
+	one, hundred := 1.0, 100.0
+	rules, err := CompileFieldRules(&config.ValidationRules{Fields: []config.FieldRule{
+		{Path: "locale", Enum: []string{"en", "ru"}},
+		{Path: "shardkey", Pattern: "^[0-9]+$"},
+		{Path: "sm_id", Min: &one, MinExclusive: true},
+		{Path: "payment.amount", Min: &one},
+		{Path: "items[].sale", Max: &hundred},
+	}})
+	require.NoError(t, err)
+
+	service := NewOrderService(new(MockRepository), nil).WithFieldRules(rules)
+
+	order := createValidOrder()
+	order.Locale = "de"
+	order.Shardkey = "x"
+	order.SmID = 1
+	order.Payment.Amount = models.MoneyFromFloat(0.5)
+	order.Items[0].Sale = 101
+	assert.Equal(t, ValidationErrors{
+		{Field: "locale", Code: CodeNotAllowed, Message: "must be one of en, ru"},
+		{Field: "shardkey", Code: CodeInvalidFormat, Message: "format is invalid"},
+		{Field: "sm_id", Code: CodeOutOfRange, Message: "must be greater than 1"},
+		{Field: "payment.amount", Code: CodeOutOfRange, Message: "must be at least 1"},
+		{Field: "items[0].sale", Code: CodeOutOfRange, Message: "must be at most 100"},
+	}, Violations(service.validateOrder(&order)))
+
+	// Fields without rules are not checked.
+	order = createValidOrder()
+	order.OrderUID = ""
+	order.Locale = "ru"
+	order.SmID = 2
+	assert.NoError(t, service.validateOrder(&order))
+
+	// Lengths are counted in characters, as by the VARCHAR columns.
+	rules, err = CompileFieldRules(&config.ValidationRules{Fields: []config.FieldRule{
+		{Path: "delivery.name", MaxLength: 6},
+	}})
+	require.NoError(t, err)
+	service = NewOrderService(new(MockRepository), nil).WithFieldRules(rules)
+	order = createValidOrder()
+	order.Delivery.Name = "Иванов"
+	assert.NoError(t, service.validateOrder(&order))
+	order.Delivery.Name = "Иванова"
+	assert.Equal(t, ValidationErrors{
+		{Field: "delivery.name", Code: CodeTooLong, Message: "must be less than 6 characters"},
+	}, Violations(service.validateOrder(&order)))
+}
+
+func TestOrderService_WatchFieldRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "validation.json")
+	require.NoError(t, os.WriteFile(path, configs.Validation, 0o644))
+
+	service := NewOrderService(new(MockRepository), nil)
+	reloads := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go service.WatchFieldRules(ctx, path, 10*time.Millisecond, func(err error) { reloads <- err })
+
+	order := createValidOrder()
+	order.Locale = "klingon"
+	assert.Error(t, service.validateOrder(&order))
+
+	// The file is written again with a later modification time until the
+	// watcher, which may not have started yet, picks it up.
+	modTime := time.Now()
+	reload := func(content string) error {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		for i := 0; i < 100; i++ {
+			modTime = modTime.Add(time.Second)
+			require.NoError(t, os.Chtimes(path, modTime, modTime))
+			select {
+			case err := <-reloads:
+				return err
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		t.Fatal("rules were not reloaded")
+		return nil
+	}
+
+	// An invalid file keeps the current rules.
+	assert.Error(t, reload(`{"fields": [{"path": "unknown"}]}`))
+	assert.Error(t, service.validateOrder(&order))
+
+	assert.NoError(t, reload(`{"fields": [{"path": "locale", "required": true}]}`))
+	assert.NoError(t, service.validateOrder(&order))
+}
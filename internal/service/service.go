@@ -5,48 +5,51 @@ import (
 	"L0/internal/metrics"
 	models "L0/internal/model"
 	"L0/internal/repository"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/goccy/go-json"
-	"regexp"
-	"strings"
-	"time"
-)
+	"sync/atomic"
 
-var (
-	emailRegex = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}$`)
-	phoneRegex = regexp.MustCompile(`^\+?[0-9]{10,15}$`)
+	"github.com/goccy/go-json"
+	"golang.org/x/sync/singleflight"
 )
 
 type OrderService struct {
 	repo  repository.Repository
 	cache *cache.Cache
+	rules ConsistencyRules
+	// fields holds the field rules, which are replaced on reload.
+	fields atomic.Pointer[FieldRules]
+	// loads coalesces concurrent database loads of the same order.
+	loads singleflight.Group
 }
 
 func NewOrderService(repo repository.Repository, cache *cache.Cache) *OrderService {
-	return &OrderService{
+	s := &OrderService{
 		repo:  repo,
 		cache: cache,
+		rules: DefaultConsistencyRules(),
 	}
+	s.fields.Store(DefaultFieldRules())
+	return s
 }
 
-func (s *OrderService) CreateOrder(order *models.Order) error {
+func (s *OrderService) CreateOrder(ctx context.Context, order *models.Order) error {
 	if err := s.validateOrder(order); err != nil {
-		return fmt.Errorf("validation error: %v", err)
+		return err
 	}
 
-	exists, err := s.repo.OrderExists(order.OrderUID)
+	exists, err := s.repo.OrderExists(ctx, order.OrderUID)
 	if err != nil {
-		return fmt.Errorf("error checking order existence: %v", err)
+		return fmt.Errorf("error checking order existence: %w: %v", ErrStorage, err)
 	}
 
 	if exists {
-		return fmt.Errorf("order %s already exists", order.OrderUID)
+		return fmt.Errorf("order %s: %w", order.OrderUID, ErrOrderExists)
 	}
 
-	if err := s.repo.SaveOrder(order); err != nil {
-		metrics.DBErrors.Inc()
-		return fmt.Errorf("error saving order: %v", err)
+	if err := s.saveOrder(ctx, order); err != nil {
+		return err
 	}
 
 	s.cache.Set(order)
@@ -55,286 +58,201 @@ func (s *OrderService) CreateOrder(order *models.Order) error {
 	return nil
 }
 
-func (s *OrderService) ProcessOrderMessage(message []byte) error {
-	var order models.Order
-	if err := json.Unmarshal(message, &order); err != nil {
-		return fmt.Errorf("error parsing message: %v", err)
-	}
-
-	if err := s.validateOrder(&order); err != nil {
-		return fmt.Errorf("validation error: %v", err)
+// ProcessOrderMessage stores a new order or applies a correction to an
+// existing one. Corrections must carry a greater version than the stored
+// order, otherwise ErrStaleVersion is returned.
+func (s *OrderService) ProcessOrderMessage(ctx context.Context, message []byte) error {
+	order, err := s.parseOrderMessage(message)
+	if err != nil {
+		return err
 	}
 
-	exists, err := s.repo.OrderExists(order.OrderUID)
+	exists, err := s.repo.OrderExists(ctx, order.OrderUID)
 	if err != nil {
-		return fmt.Errorf("error checking order existence: %v", err)
+		return fmt.Errorf("error checking order existence: %w: %v", ErrStorage, err)
 	}
 
 	if exists {
-		return fmt.Errorf("order %s already exists", order.OrderUID)
+		return s.updateOrder(ctx, order)
 	}
 
-	if err := s.repo.SaveOrder(&order); err != nil {
-		metrics.DBErrors.Inc()
-		return fmt.Errorf("error saving order: %v", err)
+	if err := s.saveOrder(ctx, order); err != nil {
+		if errors.Is(err, ErrOrderExists) {
+			return s.updateOrder(ctx, order)
+		}
+		return err
 	}
 
-	s.cache.Set(&order)
+	s.cache.Set(order)
 	metrics.OrdersProcessed.Inc()
 	metrics.KafkaMessagesReceived.Inc()
 
 	return nil
 }
 
-func (s *OrderService) GetOrderByID(orderUID string) (*models.Order, error) {
-	if order, exists := s.cache.Get(orderUID); exists {
-		metrics.OrdersFromCache.Inc()
-		return order, nil
-	}
-
-	order, err := s.repo.GetOrderByID(orderUID)
-	if err != nil {
-		metrics.DBErrors.Inc()
-		return nil, fmt.Errorf("error getting order from DB: %v", err)
+// UpdateOrder replaces an existing order. The update must carry a greater
+// version than the stored order.
+func (s *OrderService) UpdateOrder(ctx context.Context, order *models.Order) error {
+	if err := s.validateOrder(order); err != nil {
+		return err
 	}
 
-	if order != nil {
-		s.cache.Set(order)
-		metrics.OrdersFromDB.Inc()
+	if order.Version <= 0 {
+		return invalid("version", CodeRequired, "is required for updates")
 	}
 
-	return order, nil
+	return s.updateOrder(ctx, order)
 }
 
-func (s *OrderService) validateOrder(order *models.Order) error {
-	if order.OrderUID == "" {
-		return errors.New("order UID is required")
-	}
-	if len(order.OrderUID) > 255 {
-		return errors.New("order UID must be less than 255 characters")
-	}
-
-	if order.TrackNumber == "" {
-		return errors.New("track number is required")
-	}
-	if len(order.TrackNumber) > 255 {
-		return errors.New("track number must be less than 255 characters")
-	}
-
-	if order.Entry == "" {
-		return errors.New("entry is required")
-	}
-	if len(order.Entry) > 50 {
-		return errors.New("entry must be less than 50 characters")
-	}
-
-	if order.Locale == "" {
-		return errors.New("locale is required")
-	}
-	if len(order.Locale) > 10 {
-		return errors.New("locale must be less than 10 characters")
-	}
-
-	if order.CustomerID == "" {
-		return errors.New("customer ID is required")
-	}
-	if len(order.CustomerID) > 255 {
-		return errors.New("customer ID must be less than 255 characters")
-	}
-
-	if order.DeliveryService == "" {
-		return errors.New("delivery service is required")
-	}
-	if len(order.DeliveryService) > 100 {
-		return errors.New("delivery service must be less than 100 characters")
-	}
-
-	if order.DateCreated.IsZero() {
-		return errors.New("date created is required")
-	}
-	if order.DateCreated.After(time.Now().Add(24 * time.Hour)) {
-		return errors.New("date created cannot be in the future")
-	}
-
-	if order.Delivery.Name == "" {
-		return errors.New("delivery name is required")
-	}
-	if len(order.Delivery.Name) > 255 {
-		return errors.New("delivery name must be less than 255 characters")
-	}
-
-	if order.Delivery.Phone == "" {
-		return errors.New("delivery phone is required")
-	}
-	if !isValidPhone(order.Delivery.Phone) {
-		return errors.New("delivery phone format is invalid")
+func (s *OrderService) updateOrder(ctx context.Context, order *models.Order) error {
+	err := s.repo.UpdateOrder(ctx, order)
+	switch {
+	case errors.Is(err, repository.ErrOrderNotFound):
+		return fmt.Errorf("order %s: %w", order.OrderUID, ErrNotFound)
+	case errors.Is(err, repository.ErrStaleVersion):
+		return fmt.Errorf("order %s version %d: %w", order.OrderUID, order.Version, ErrStaleVersion)
+	case err != nil:
+		metrics.DBErrors.Inc()
+		return fmt.Errorf("error updating order: %w: %v", ErrStorage, err)
 	}
 
-	if order.Delivery.Zip == "" {
-		return errors.New("delivery zip is required")
-	}
-	if len(order.Delivery.Zip) > 50 {
-		return errors.New("delivery zip must be less than 50 characters")
-	}
+	s.cache.Set(order)
+	metrics.OrdersUpdated.Inc()
 
-	if order.Delivery.City == "" {
-		return errors.New("delivery city is required")
-	}
-	if len(order.Delivery.City) > 255 {
-		return errors.New("delivery city must be less than 255 characters")
-	}
+	return nil
+}
 
-	if order.Delivery.Address == "" {
-		return errors.New("delivery address is required")
-	}
-	if len(order.Delivery.Address) > 500 {
-		return errors.New("delivery address must be less than 500 characters")
+// ProcessOrderMessages stores a batch of messages in one transaction. The
+// returned errors are aligned with messages; a nil entry means the order
+// was stored.
+func (s *OrderService) ProcessOrderMessages(ctx context.Context, messages [][]byte) []error {
+	errs := make([]error, len(messages))
+	orders := make([]*models.Order, 0, len(messages))
+	positions := make([]int, 0, len(messages))
+
+	for i, message := range messages {
+		order, err := s.parseOrderMessage(message)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		orders = append(orders, order)
+		positions = append(positions, i)
 	}
 
-	if order.Delivery.Region == "" {
-		return errors.New("delivery region is required")
-	}
-	if len(order.Delivery.Region) > 255 {
-		return errors.New("delivery region must be less than 255 characters")
+	if len(orders) == 0 {
+		return errs
 	}
 
-	if order.Delivery.Email == "" {
-		return errors.New("delivery email is required")
-	}
-	if !isValidEmail(order.Delivery.Email) {
-		return errors.New("delivery email format is invalid")
+	for _, order := range orders {
+		if order.Version <= 0 {
+			order.Version = 1
+		}
 	}
 
-	if order.Payment.Transaction == "" {
-		return errors.New("payment transaction is required")
-	}
-	if len(order.Payment.Transaction) > 255 {
-		return errors.New("payment transaction must be less than 255 characters")
+	saveErrs, err := s.repo.SaveOrders(ctx, orders)
+	if err != nil {
+		metrics.DBErrors.Inc()
+		for _, pos := range positions {
+			errs[pos] = fmt.Errorf("error saving orders: %w: %v", ErrStorage, err)
+		}
+		return errs
+	}
+
+	for i, order := range orders {
+		switch err := saveErrs[i]; {
+		case err == nil:
+			s.cache.Set(order)
+			metrics.OrdersProcessed.Inc()
+			metrics.KafkaMessagesReceived.Inc()
+		case errors.Is(err, repository.ErrOrderExists):
+			errs[positions[i]] = s.updateOrder(ctx, order)
+		default:
+			metrics.DBErrors.Inc()
+			errs[positions[i]] = fmt.Errorf("error saving order %s: %v", order.OrderUID, err)
+		}
 	}
 
-	if order.Payment.Currency == "" {
-		return errors.New("payment currency is required")
-	}
-	if len(order.Payment.Currency) > 10 {
-		return errors.New("payment currency must be less than 10 characters")
-	}
+	return errs
+}
 
-	if order.Payment.Provider == "" {
-		return errors.New("payment provider is required")
-	}
-	if len(order.Payment.Provider) > 100 {
-		return errors.New("payment provider must be less than 100 characters")
+func (s *OrderService) parseOrderMessage(message []byte) (*models.Order, error) {
+	var order models.Order
+	if err := json.Unmarshal(message, &order); err != nil {
+		return nil, fmt.Errorf("error parsing message: %v", err)
 	}
 
-	if order.Payment.Amount <= 0 {
-		return errors.New("payment amount must be positive")
+	if err := s.validateOrder(&order); err != nil {
+		return nil, err
 	}
 
-	if order.Payment.PaymentDT <= 0 {
-		return errors.New("payment date is required")
-	}
+	return &order, nil
+}
 
-	if order.Payment.Bank == "" {
-		return errors.New("payment bank is required")
-	}
-	if len(order.Payment.Bank) > 100 {
-		return errors.New("payment bank must be less than 100 characters")
+func (s *OrderService) saveOrder(ctx context.Context, order *models.Order) error {
+	if order.Version <= 0 {
+		order.Version = 1
 	}
 
-	if order.Payment.DeliveryCost < 0 {
-		return errors.New("payment delivery cost cannot be negative")
+	err := s.repo.SaveOrder(ctx, order)
+	if errors.Is(err, repository.ErrOrderExists) {
+		return fmt.Errorf("order %s: %w", order.OrderUID, ErrOrderExists)
 	}
-
-	if order.Payment.GoodsTotal <= 0 {
-		return errors.New("payment goods total must be positive")
+	if err != nil {
+		metrics.DBErrors.Inc()
+		return fmt.Errorf("error saving order: %w: %v", ErrStorage, err)
 	}
+	return nil
+}
 
-	if order.Payment.CustomFee < 0 {
-		return errors.New("payment custom fee cannot be negative")
+func (s *OrderService) GetOrderByID(ctx context.Context, orderUID string) (*models.Order, error) {
+	if order, exists := s.cache.Get(orderUID); exists {
+		metrics.OrdersFromCache.Inc()
+		return order, nil
 	}
 
-	if len(order.Items) == 0 {
-		return errors.New("order must have at least one item")
+	if s.cache.Missing(orderUID) {
+		return nil, nil
 	}
 
-	for i, item := range order.Items {
-		if item.ChrtID <= 0 {
-			return fmt.Errorf("item %d chrt_id must be positive", i)
-		}
+	// Concurrent misses of the same order wait for a single load. The load
+	// is not cancelled with the caller that started it, as others may be
+	// waiting for it; each caller stops waiting when its own ctx is done.
+	leader := false
+	loaded := s.loads.DoChan(orderUID, func() (any, error) {
+		leader = true
 
-		if item.TrackNumber == "" {
-			return fmt.Errorf("item %d track number is required", i)
-		}
-		if len(item.TrackNumber) > 255 {
-			return fmt.Errorf("item %d track number must be less than 255 characters", i)
+		token := s.cache.MissingToken(orderUID)
+		order, err := s.repo.GetOrderByID(context.WithoutCancel(ctx), orderUID)
+		if err != nil {
+			metrics.DBErrors.Inc()
+			return nil, fmt.Errorf("error getting order from DB: %w: %v", ErrStorage, err)
 		}
 
-		if item.Price <= 0 {
-			return fmt.Errorf("item %d price must be positive", i)
-		}
-
-		if item.Rid == "" {
-			return fmt.Errorf("item %d rid is required", i)
-		}
-		if len(item.Rid) > 255 {
-			return fmt.Errorf("item %d rid must be less than 255 characters", i)
+		if order == nil {
+			s.cache.SetMissing(orderUID, token)
+			return nil, nil
 		}
 
-		if item.Name == "" {
-			return fmt.Errorf("item %d name is required", i)
-		}
-		if len(item.Name) > 255 {
-			return fmt.Errorf("item %d name must be less than 255 characters", i)
-		}
-
-		if item.Sale < 0 {
-			return fmt.Errorf("item %d sale cannot be negative", i)
-		}
-
-		if item.Size == "" {
-			return fmt.Errorf("item %d size is required", i)
-		}
-		if len(item.Size) > 50 {
-			return fmt.Errorf("item %d size must be less than 50 characters", i)
-		}
-
-		if item.TotalPrice <= 0 {
-			return fmt.Errorf("item %d total price must be positive", i)
-		}
-
-		if item.NmID <= 0 {
-			return fmt.Errorf("item %d nm_id must be positive", i)
-		}
-
-		if item.Brand == "" {
-			return fmt.Errorf("item %d brand is required", i)
-		}
-		if len(item.Brand) > 255 {
-			return fmt.Errorf("item %d brand must be less than 255 characters", i)
-		}
+		s.cache.Set(order)
+		metrics.OrdersFromDB.Inc()
+		return order, nil
+	})
 
-		if item.Status < 0 {
-			return fmt.Errorf("item %d status cannot be negative", i)
-		}
+	var result singleflight.Result
+	select {
+	case result = <-loaded:
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 
-	return nil
-}
-
-func isValidEmail(email string) bool {
-	return emailRegex.MatchString(strings.ToLower(email))
-}
-
-func isValidPhone(phone string) bool {
-	return phoneRegex.MatchString(phone)
-}
-
-func (s *OrderService) RestoreCacheFromDB() error {
-	orders, err := s.repo.LoadAllOrders()
-	if err != nil {
-		return fmt.Errorf("error loading orders from DB: %v", err)
+	if result.Shared && !leader {
+		metrics.OrdersCoalesced.Inc()
+	}
+	if result.Err != nil {
+		return nil, result.Err
 	}
 
-	s.cache.Restore(orders)
-	return nil
+	order, _ := result.Val.(*models.Order)
+	return order, nil
 }
@@ -3,24 +3,46 @@ package service
 import (
 	"L0/internal/cache"
 	"L0/internal/model"
+	"L0/internal/repository"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) SaveOrder(order *models.Order) error {
+func (m *MockRepository) SaveOrder(ctx context.Context, order *models.Order) error {
 	args := m.Called(order)
 	return args.Error(0)
 }
 
-func (m *MockRepository) GetOrderByID(orderUID string) (*models.Order, error) {
+func (m *MockRepository) SaveOrders(ctx context.Context, orders []*models.Order) ([]error, error) {
+	args := m.Called(orders)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]error), args.Error(1)
+}
+
+func (m *MockRepository) UpdateOrder(ctx context.Context, order *models.Order) error {
+	args := m.Called(order)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetOrderByID(ctx context.Context, orderUID string) (*models.Order, error) {
 	args := m.Called(orderUID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -28,17 +50,70 @@ func (m *MockRepository) GetOrderByID(orderUID string) (*models.Order, error) {
 	return args.Get(0).(*models.Order), args.Error(1)
 }
 
-func (m *MockRepository) LoadAllOrders() ([]*models.Order, error) {
-	args := m.Called()
+func (m *MockRepository) GetOrdersByIDs(ctx context.Context, orderUIDs []string) ([]*models.Order, error) {
+	args := m.Called(orderUIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Order), args.Error(1)
+}
+
+func (m *MockRepository) StreamOrders(ctx context.Context, filter repository.OrderFilter, chunkSize int, fn func([]*models.Order) error) error {
+	args := m.Called(filter, chunkSize)
+	for _, chunk := range args.Get(0).([][]*models.Order) {
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockRepository) ListOrders(ctx context.Context, filter repository.OrderFilter) ([]*models.Order, error) {
+	args := m.Called(filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Order), args.Error(1)
+}
+
+func (m *MockRepository) FindOrdersByTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error) {
+	args := m.Called(trackNumber)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
 	return args.Get(0).([]*models.Order), args.Error(1)
 }
 
-func (m *MockRepository) OrderExists(orderUID string) (bool, error) {
+func (m *MockRepository) FindOrdersByItemTrackNumber(ctx context.Context, trackNumber string) ([]*models.Order, error) {
+	args := m.Called(trackNumber)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Order), args.Error(1)
+}
+
+func (m *MockRepository) FindOrdersByChrtID(ctx context.Context, chrtID int) ([]*models.Order, error) {
+	args := m.Called(chrtID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Order), args.Error(1)
+}
+
+func (m *MockRepository) FindOrdersByNmID(ctx context.Context, nmID int) ([]*models.Order, error) {
+	args := m.Called(nmID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Order), args.Error(1)
+}
+
+func (m *MockRepository) OrderExists(ctx context.Context, orderUID string) (bool, error) {
 	args := m.Called(orderUID)
 	return args.Bool(0), args.Error(1)
 }
 
-func (m *MockRepository) Ping() error {
+func (m *MockRepository) Ping(ctx context.Context) error {
 	args := m.Called()
 	return args.Error(0)
 }
@@ -75,23 +150,23 @@ func createValidOrder() models.Order {
 			RequestID:    "",
 			Currency:     "USD",
 			Provider:     "wbpay",
-			Amount:       1817,
+			Amount:       models.MoneyFromFloat(1817),
 			PaymentDT:    1637907727,
 			Bank:         "alpha",
-			DeliveryCost: 1500,
-			GoodsTotal:   317,
+			DeliveryCost: models.MoneyFromFloat(1500),
+			GoodsTotal:   models.MoneyFromFloat(317),
 			CustomFee:    0,
 		},
 		Items: []models.Item{
 			{
 				ChrtID:      9934930,
 				TrackNumber: "TRACK123",
-				Price:       453,
+				Price:       models.MoneyFromFloat(453),
 				Rid:         "ab4219087a764ae0btest",
 				Name:        "Mascaras",
 				Sale:        30,
 				Size:        "0",
-				TotalPrice:  317,
+				TotalPrice:  models.MoneyFromFloat(317),
 				NmID:        2389212,
 				Brand:       "Vivienne Sabo",
 				Status:      202,
@@ -113,7 +188,7 @@ func TestOrderService_ProcessOrderMessage(t *testing.T) {
 	mockRepo.On("OrderExists", order.OrderUID).Return(false, nil)
 	mockRepo.On("SaveOrder", mock.AnythingOfType("*models.Order")).Return(nil)
 
-	err := service.ProcessOrderMessage(orderJSON)
+	err := service.ProcessOrderMessage(context.Background(), orderJSON)
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 }
@@ -131,12 +206,192 @@ func TestOrderService_ProcessOrderMessage_Invalid(t *testing.T) {
 
 	invalidOrderJSON, _ := json.Marshal(invalidOrder)
 
-	err := service.ProcessOrderMessage(invalidOrderJSON)
+	err := service.ProcessOrderMessage(context.Background(), invalidOrderJSON)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "validation error")
 	mockRepo.AssertNotCalled(t, "SaveOrder")
 }
 
+func TestOrderService_ValidationErrors(t *testing.T) {
+	service := NewOrderService(new(MockRepository), cache.New(1024*1024, time.Minute))
+
+	order := createValidOrder()
+	order.Delivery.Phone = "phone"
+	order.Payment.Currency = ""
+	order.Items[0].Price = 0
+	order.Items[0].Brand = strings.Repeat("b", 256)
+	err := service.CreateOrder(context.Background(), &order)
+
+	// Every violation is reported, not only the first.
+	assert.True(t, IsValidation(err))
+	assert.Equal(t, ValidationErrors{
+		{Field: "delivery.phone", Code: CodeInvalidFormat, Message: "format is invalid"},
+		{Field: "payment.currency", Code: CodeRequired, Message: "is required"},
+		{Field: "items[0].price", Code: CodeNotPositive, Message: "must be positive"},
+		{Field: "items[0].brand", Code: CodeTooLong, Message: "must be less than 255 characters"},
+	}, Violations(err))
+
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, "delivery.phone", verr.Field)
+
+	assert.JSONEq(t, `[{"field":"items","code":"required","message":"must have at least one item"}]`,
+		string(EncodeViolations(fmt.Errorf("order: %w", ValidationErrors{invalid("items", CodeRequired, "must have at least one item")}))))
+
+	storageErr := fmt.Errorf("error saving order: %w: %v", ErrStorage, errors.New("connection refused"))
+	assert.False(t, IsValidation(storageErr))
+	assert.Nil(t, Violations(storageErr))
+	assert.Nil(t, EncodeViolations(storageErr))
+}
+
+func TestOrderService_CurrencyAndLocale(t *testing.T) {
+	service := NewOrderService(new(MockRepository), cache.New(1024*1024, time.Minute))
+
+	order := createValidOrder()
+	order.Locale = "klingon"
+	order.Payment.Currency = "XYZ"
+	assert.Equal(t, ValidationErrors{
+		{Field: "locale", Code: CodeInvalidFormat, Message: "is not a BCP 47 language tag"},
+		{Field: "payment.currency", Code: CodeInvalidFormat, Message: "is not an ISO 4217 currency code"},
+	}, Violations(service.validateOrder(&order)))
+
+	order = createValidOrder()
+	order.Locale = "ru-RU"
+	order.Payment.Currency = "KWD"
+	order.Payment.DeliveryCost = models.MoneyFromFloat(1499.995)
+	order.Payment.CustomFee = models.MoneyFromFloat(0.005)
+	assert.NoError(t, service.validateOrder(&order))
+
+	// Yen have no minor unit.
+	order = createValidOrder()
+	order.Payment.Currency = "JPY"
+	order.Payment.DeliveryCost = models.MoneyFromFloat(1499.5)
+	order.Payment.CustomFee = models.MoneyFromFloat(0.5)
+	order.Items[0].Price = models.MoneyFromFloat(452.5)
+	assert.Equal(t, ValidationErrors{
+		{Field: "payment.delivery_cost", Code: CodeTooPrecise, Message: "must have at most 0 decimal places in JPY"},
+		{Field: "payment.custom_fee", Code: CodeTooPrecise, Message: "must have at most 0 decimal places in JPY"},
+		{Field: "items[0].price", Code: CodeTooPrecise, Message: "must have at most 0 decimal places in JPY"},
+	}, Violations(service.validateOrder(&order)))
+
+	order = createValidOrder()
+	order.Payment.DeliveryCost = models.MoneyFromFloat(1499.999)
+	order.Payment.CustomFee = models.MoneyFromFloat(0.001)
+	assert.Equal(t, ValidationErrors{
+		{Field: "payment.delivery_cost", Code: CodeTooPrecise, Message: "must have at most 2 decimal places in USD"},
+		{Field: "payment.custom_fee", Code: CodeTooPrecise, Message: "must have at most 2 decimal places in USD"},
+	}, Violations(service.validateOrder(&order)))
+}
+
+func TestOrderService_AmountTooLarge(t *testing.T) {
+	service := NewOrderService(new(MockRepository), cache.New(1024*1024, time.Minute))
+
+	// Amounts that do not fit the money columns are invalid rather than a
+	// storage error.
+	order := createValidOrder()
+	order.Items[0].Price = models.MoneyFromFloat(1e13)
+	err := service.validateOrder(&order)
+	assert.True(t, IsValidation(err))
+	assert.Equal(t, ValidationErrors{
+		{Field: "items[0].price", Code: CodeOutOfRange, Message: "must be at most 1000000000000"},
+	}, Violations(err))
+}
+
+func TestOrderService_ConsistencyRules(t *testing.T) {
+	service := NewOrderService(new(MockRepository), cache.New(1024*1024, time.Minute))
+
+	// The test order adds up, apart from its item total price which is
+	// rounded down within the tolerance.
+	order := createValidOrder()
+	assert.NoError(t, service.validateOrder(&order))
+	assert.Empty(t, order.Warnings)
+
+	order = createValidOrder()
+	order.Payment.GoodsTotal = models.MoneyFromFloat(300)
+	order.Payment.Amount = models.MoneyFromFloat(1800)
+	assert.Equal(t, ValidationErrors{
+		{Field: "payment.goods_total", Code: CodeMismatch, Message: "must equal the sum of item total prices (317)"},
+	}, Violations(service.validateOrder(&order)))
+
+	order = createValidOrder()
+	order.Payment.CustomFee = models.MoneyFromFloat(10)
+	assert.Equal(t, ValidationErrors{
+		{Field: "payment.amount", Code: CodeMismatch, Message: "must equal goods_total + delivery_cost + custom_fee (1827)"},
+	}, Violations(service.validateOrder(&order)))
+
+	// Amounts are shown with all their digits, such as the three decimal
+	// places of KWD.
+	order = createValidOrder()
+	order.Payment.Currency = "KWD"
+	order.Payment.DeliveryCost += models.MoneyFromFloat(0.125)
+	assert.Equal(t, ValidationErrors{
+		{Field: "payment.amount", Code: CodeMismatch, Message: "must equal goods_total + delivery_cost + custom_fee (1817.125)"},
+	}, Violations(service.validateOrder(&order)))
+
+	// Within the tolerance.
+	order = createValidOrder()
+	order.Payment.Currency = "KWD"
+	order.Payment.Amount = models.MoneyFromFloat(1817.005)
+	assert.NoError(t, service.validateOrder(&order))
+
+	// Warnings accept the order and replace the ones it came with.
+	order = createValidOrder()
+	order.Items[0].Sale = 50
+	order.Warnings = models.Warnings{{Rule: "forged"}}
+	assert.NoError(t, service.validateOrder(&order))
+	assert.Equal(t, models.Warnings{
+		{Rule: RuleItemTotal, Field: "items[0].total_price", Message: "must be price less sale (226.5)"},
+	}, order.Warnings)
+
+	// Consistency is not checked while fields are invalid.
+	order = createValidOrder()
+	order.Payment.GoodsTotal = models.MoneyFromFloat(300)
+	order.Items[0].Price = 0
+	assert.Len(t, Violations(service.validateOrder(&order)), 1)
+}
+
+func TestOrderService_WithConsistencyRules(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewOrderService(mockRepo, cache.New(1024*1024, time.Minute)).
+		WithConsistencyRules(ConsistencyRules{RuleGoodsTotal: {Severity: SeverityWarn, Tolerance: models.MoneyFromFloat(0.01)}})
+
+	order := createValidOrder()
+	order.Payment.GoodsTotal = models.MoneyFromFloat(300)
+	order.Payment.Amount = models.MoneyFromFloat(1)
+
+	mockRepo.On("OrderExists", order.OrderUID).Return(false, nil)
+	mockRepo.On("SaveOrder", mock.MatchedBy(func(o *models.Order) bool {
+		return len(o.Warnings) == 1 && o.Warnings[0].Rule == RuleGoodsTotal
+	})).Return(nil)
+
+	assert.NoError(t, service.CreateOrder(context.Background(), &order))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestParseConsistencyRules(t *testing.T) {
+	rules, err := ParseConsistencyRules("goods_total:reject:0.01, item_total_price:warn:1")
+	require.NoError(t, err)
+	assert.Equal(t, ConsistencyRules{
+		RuleGoodsTotal: {Severity: SeverityReject, Tolerance: models.MoneyFromFloat(0.01)},
+		RuleItemTotal:  {Severity: SeverityWarn, Tolerance: models.MoneyFromFloat(1)},
+	}, rules)
+
+	rules, err = ParseConsistencyRules("")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	for _, value := range []string{
+		"goods_total",
+		"unknown:reject:0",
+		"amount:ignore:0",
+		"amount:warn:-1",
+		"amount:warn:x",
+	} {
+		_, err := ParseConsistencyRules(value)
+		assert.Error(t, err, value)
+	}
+}
+
 func TestOrderService_GetOrderByID(t *testing.T) {
 	mockRepo := new(MockRepository)
 	cache := cache.New(1024*1024, time.Minute)
@@ -147,17 +402,145 @@ func TestOrderService_GetOrderByID(t *testing.T) {
 
 	cache.Set(&testOrder)
 
-	order, err := service.GetOrderByID("test123")
+	order, err := service.GetOrderByID(context.Background(), "test123")
 	assert.NoError(t, err)
 	assert.Equal(t, testOrder.OrderUID, order.OrderUID)
 
 	mockRepo.On("GetOrderByID", "test456").Return(&testOrder, nil)
-	order, err = service.GetOrderByID("test456")
+	order, err = service.GetOrderByID(context.Background(), "test456")
 	assert.NoError(t, err)
 	assert.Equal(t, testOrder.OrderUID, order.OrderUID)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestOrderService_GetOrderByID_CoalescesMisses(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	testOrder := createValidOrder()
+	release := make(chan struct{})
+	mockRepo.On("GetOrderByID", "test123").
+		Run(func(mock.Arguments) { <-release }).
+		Return(&testOrder, nil)
+
+	const callers = 10
+	var started, done sync.WaitGroup
+	started.Add(callers)
+	done.Add(callers)
+	orders := make([]*models.Order, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer done.Done()
+			started.Done()
+			orders[i], errs[i] = service.GetOrderByID(context.Background(), "test123")
+		}(i)
+	}
+
+	// Give every caller the time to miss the cache and join the load.
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	for i := 0; i < callers; i++ {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, &testOrder, orders[i])
+	}
+	mockRepo.AssertNumberOfCalls(t, "GetOrderByID", 1)
+}
+
+func TestOrderService_GetOrderByID_CallerCancelled(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewOrderService(mockRepo, cache.New(1024*1024, time.Minute))
+
+	testOrder := createValidOrder()
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	mockRepo.On("GetOrderByID", "test123").
+		Run(func(mock.Arguments) {
+			close(loading)
+			<-release
+		}).
+		Return(&testOrder, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := service.GetOrderByID(ctx, "test123")
+		cancelled <- err
+	}()
+	<-loading
+
+	waiting := make(chan *models.Order, 1)
+	go func() {
+		order, _ := service.GetOrderByID(context.Background(), "test123")
+		waiting <- order
+	}()
+
+	// The caller that started the load stops waiting, the load goes on.
+	cancel()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+	close(release)
+	assert.Equal(t, &testOrder, <-waiting)
+	mockRepo.AssertNumberOfCalls(t, "GetOrderByID", 1)
+}
+
+func TestOrderService_GetOrderByID_NegativeCache(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute, cache.WithNegativeCache(time.Minute, 100))
+
+	service := NewOrderService(mockRepo, cache)
+
+	mockRepo.On("GetOrderByID", "test123").Return(nil, nil)
+
+	// The second lookup of an unknown order does not reach the database.
+	for i := 0; i < 2; i++ {
+		order, err := service.GetOrderByID(context.Background(), "test123")
+		assert.NoError(t, err)
+		assert.Nil(t, order)
+	}
+	mockRepo.AssertNumberOfCalls(t, "GetOrderByID", 1)
+
+	// Creating the order makes it visible at once.
+	testOrder := createValidOrder()
+	mockRepo.On("OrderExists", testOrder.OrderUID).Return(false, nil)
+	mockRepo.On("SaveOrder", &testOrder).Return(nil)
+	assert.NoError(t, service.CreateOrder(context.Background(), &testOrder))
+
+	order, err := service.GetOrderByID(context.Background(), "test123")
+	assert.NoError(t, err)
+	assert.Equal(t, &testOrder, order)
+}
+
+func TestOrderService_ProcessOrderMessage_InvalidatesNegativeCache(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute, cache.WithNegativeCache(time.Minute, 100))
+
+	service := NewOrderService(mockRepo, cache)
+
+	mockRepo.On("GetOrderByID", "test123").Return(nil, nil).Once()
+	order, err := service.GetOrderByID(context.Background(), "test123")
+	assert.NoError(t, err)
+	assert.Nil(t, order)
+	assert.True(t, cache.Missing("test123"))
+
+	testOrder := createValidOrder()
+	orderJSON, _ := json.Marshal(testOrder)
+	mockRepo.On("OrderExists", testOrder.OrderUID).Return(false, nil)
+	mockRepo.On("SaveOrder", mock.AnythingOfType("*models.Order")).Return(nil)
+	assert.NoError(t, service.ProcessOrderMessage(context.Background(), orderJSON))
+
+	assert.False(t, cache.Missing("test123"))
+	order, err = service.GetOrderByID(context.Background(), "test123")
+	assert.NoError(t, err)
+	assert.Equal(t, testOrder.OrderUID, order.OrderUID)
+	mockRepo.AssertNumberOfCalls(t, "GetOrderByID", 1)
+}
+
 func TestOrderService_CreateOrder(t *testing.T) {
 	mockRepo := new(MockRepository)
 	cache := cache.New(1024*1024, time.Minute)
@@ -169,7 +552,7 @@ func TestOrderService_CreateOrder(t *testing.T) {
 	mockRepo.On("OrderExists", testOrder.OrderUID).Return(false, nil)
 	mockRepo.On("SaveOrder", &testOrder).Return(nil)
 
-	err := service.CreateOrder(&testOrder)
+	err := service.CreateOrder(context.Background(), &testOrder)
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 }
@@ -184,29 +567,676 @@ func TestOrderService_CreateOrder_AlreadyExists(t *testing.T) {
 
 	mockRepo.On("OrderExists", testOrder.OrderUID).Return(true, nil)
 
-	err := service.CreateOrder(&testOrder)
+	err := service.CreateOrder(context.Background(), &testOrder)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "already exists")
 	mockRepo.AssertNotCalled(t, "SaveOrder")
 }
 
-func TestOrderService_RestoreCacheFromDB(t *testing.T) {
+func TestOrderService_WarmUpCache(t *testing.T) {
 	mockRepo := new(MockRepository)
 	cache := cache.New(1024*1024, time.Minute)
 
 	service := NewOrderService(mockRepo, cache)
 
 	testOrder := createValidOrder()
-	orders := []*models.Order{&testOrder}
+	anotherOrder := createValidOrder()
+	anotherOrder.OrderUID = "test456"
+	chunks := [][]*models.Order{{&testOrder}, {&anotherOrder}}
 
-	mockRepo.On("LoadAllOrders").Return(orders, nil)
+	mockRepo.On("StreamOrders", repository.OrderFilter{}, warmUpChunkSize).Return(chunks, nil)
 
-	err := service.RestoreCacheFromDB()
+	err := service.WarmUpCache(context.Background(), WarmUpOptions{})
 	assert.NoError(t, err)
 
 	order, exists := cache.Get("test123")
 	assert.True(t, exists)
 	assert.Equal(t, testOrder.OrderUID, order.OrderUID)
 
+	order, exists = cache.Get("test456")
+	assert.True(t, exists)
+	assert.Equal(t, anotherOrder.OrderUID, order.OrderUID)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_WarmUpCache_KeepsNewerOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	// The order is updated after the warm-up has read it.
+	stale := createValidOrder()
+	updated := createValidOrder()
+	updated.Version = stale.Version + 1
+	updated.TrackNumber = "UPDATED"
+	cache.Set(&updated)
+
+	mockRepo.On("StreamOrders", repository.OrderFilter{}, warmUpChunkSize).Return([][]*models.Order{{&stale}}, nil)
+
+	err := service.WarmUpCache(context.Background(), WarmUpOptions{})
+	assert.NoError(t, err)
+
+	order, exists := cache.Get(updated.OrderUID)
+	assert.True(t, exists)
+	assert.Equal(t, "UPDATED", order.TrackNumber)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_WarmUpCache_StopsAtBudget(t *testing.T) {
+	mockRepo := new(MockRepository)
+
+	var chunks [][]*models.Order
+	for i := 0; i < 3; i++ {
+		var chunk []*models.Order
+		for j := 0; j < 2; j++ {
+			order := createValidOrder()
+			order.OrderUID = fmt.Sprintf("order-%d-%d", i, j)
+			chunk = append(chunk, &order)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	// Room for two orders only
+	cache := cache.New(2*cache.StructSize(chunks[0][0])+1, time.Minute, cache.WithShards(1))
+
+	service := NewOrderService(mockRepo, cache)
+
+	mockRepo.On("StreamOrders", repository.OrderFilter{}, warmUpChunkSize).Return(chunks, nil)
+
+	err := service.WarmUpCache(context.Background(), WarmUpOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, cache.ItemsCount())
+	assert.LessOrEqual(t, cache.CurrentSize(), cache.MaxSize())
+
+	_, exists := cache.Get("order-0-1")
+	assert.True(t, exists)
+	_, exists = cache.Get("order-1-0")
+	assert.False(t, exists)
+}
+
+func TestOrderService_WarmUpCache_FillsShards(t *testing.T) {
+	mockRepo := new(MockRepository)
+
+	order := func(uid string) *models.Order {
+		order := createValidOrder()
+		order.OrderUID = uid
+		return &order
+	}
+	size := cache.StructSize(order("order-0"))
+	large := order("large")
+	for cache.StructSize(large) <= 3*size {
+		large.Items = append(large.Items, models.Item{})
+	}
+
+	// Room for four orders, shared by four shards
+	cache := cache.New(4*size, time.Minute, cache.WithShards(4))
+
+	service := NewOrderService(mockRepo, cache)
+
+	chunks := [][]*models.Order{
+		{order("order-0"), large, order("order-1")},
+		{order("order-2"), order("order-3")},
+		{order("order-4")},
+	}
+	mockRepo.On("StreamOrders", repository.OrderFilter{}, warmUpChunkSize).Return(chunks, nil)
+
+	// The large order does not fit, but the orders after it still do.
+	err := service.WarmUpCache(context.Background(), WarmUpOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, cache.ItemsCount())
+	assert.LessOrEqual(t, cache.CurrentSize(), cache.MaxSize())
+
+	for _, uid := range []string{"order-0", "order-1", "order-2", "order-3"} {
+		_, exists := cache.Get(uid)
+		assert.True(t, exists, uid)
+	}
+}
+
+func TestOrderService_WarmUpCache_HotSetFirst(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	hotOrder := createValidOrder()
+	hotOrder.OrderUID = "hot"
+	recentOrder := createValidOrder()
+	recentOrder.OrderUID = "recent"
+	otherOrder := createValidOrder()
+	otherOrder.OrderUID = "other"
+
+	mockRepo.On("GetOrdersByIDs", []string{"hot", "unknown"}).Return([]*models.Order{&hotOrder}, nil)
+	mockRepo.On("StreamOrders", repository.OrderFilter{}, warmUpChunkSize).
+		Return([][]*models.Order{{&recentOrder, &hotOrder}, {&otherOrder}}, nil)
+
+	err := service.WarmUpCache(context.Background(), WarmUpOptions{MaxOrders: 2, HotSet: []string{"hot", "unknown"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, cache.ItemsCount())
+
+	_, exists := cache.Get("hot")
+	assert.True(t, exists)
+	_, exists = cache.Get("recent")
+	assert.True(t, exists)
+	_, exists = cache.Get("other")
+	assert.False(t, exists)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_WarmUpCache_CountsStoredOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	stale := createValidOrder()
+	stale.OrderUID = "updated"
+	updated := stale
+	updated.Version++
+	cache.Set(&updated)
+
+	first := createValidOrder()
+	first.OrderUID = "first"
+	second := createValidOrder()
+	second.OrderUID = "second"
+
+	mockRepo.On("StreamOrders", repository.OrderFilter{}, warmUpChunkSize).
+		Return([][]*models.Order{{&stale, &first}, {&second}}, nil)
+
+	// The stale copy is not stored, so it does not use up the budget.
+	err := service.WarmUpCache(context.Background(), WarmUpOptions{MaxOrders: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, cache.ItemsCount())
+
+	_, exists := cache.Get("second")
+	assert.True(t, exists)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_WarmUpCache_Error(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	mockRepo.On("StreamOrders", repository.OrderFilter{}, warmUpChunkSize).Return([][]*models.Order{}, errors.New("connection refused"))
+
+	err := service.WarmUpCache(context.Background(), WarmUpOptions{})
+	assert.Error(t, err)
+}
+
+func TestOrderService_LoadCacheSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	stale := createValidOrder()
+	other := createValidOrder()
+	other.OrderUID = "test456"
+
+	saved := cache.New(1024*1024, time.Minute)
+	saved.Set(&stale)
+	saved.Set(&other)
+	assert.NoError(t, saved.SaveSnapshot(path))
+
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+	service := NewOrderService(mockRepo, cache)
+
+	// test123 changed after the snapshot, new789 was created after it.
+	changed := createValidOrder()
+	changed.TrackNumber = "TRACK456"
+	changed.Version = 2
+	created := createValidOrder()
+	created.OrderUID = "new789"
+
+	mockRepo.On("StreamOrders", mock.MatchedBy(func(filter repository.OrderFilter) bool {
+		return !filter.UpdatedSince.IsZero() && filter.UpdatedSince.Before(time.Now().Add(-reconcileMargin/2))
+	}), warmUpChunkSize).Return([][]*models.Order{{&created, &changed}}, nil)
+
+	assert.NoError(t, service.LoadCacheSnapshot(context.Background(), path))
+	assert.Equal(t, 2, cache.ItemsCount())
+
+	order, exists := cache.Get("test123")
+	assert.True(t, exists)
+	assert.Equal(t, "TRACK456", order.TrackNumber)
+
+	_, exists = cache.Get("test456")
+	assert.True(t, exists)
+	_, exists = cache.Get("new789")
+	assert.False(t, exists)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_LoadCacheSnapshot_DatabaseClock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	// The database clock is behind: the newest order in the snapshot was
+	// changed an hour before the application saved it.
+	updatedAt := time.Now().Add(-time.Hour).UTC()
+	order := createValidOrder()
+	order.UpdatedAt = updatedAt
+
+	saved := cache.New(1024*1024, time.Minute)
+	saved.Set(&order)
+	assert.NoError(t, saved.SaveSnapshot(path))
+
+	mockRepo := new(MockRepository)
+	service := NewOrderService(mockRepo, cache.New(1024*1024, time.Minute))
+	mockRepo.On("StreamOrders", mock.MatchedBy(func(filter repository.OrderFilter) bool {
+		return filter.UpdatedSince.Equal(updatedAt.Add(-reconcileMargin))
+	}), warmUpChunkSize).Return([][]*models.Order{}, nil)
+
+	assert.NoError(t, service.LoadCacheSnapshot(context.Background(), path))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_LoadCacheSnapshot_ReconcileFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	saved := cache.New(1024*1024, time.Minute)
+	testOrder := createValidOrder()
+	saved.Set(&testOrder)
+	assert.NoError(t, saved.SaveSnapshot(path))
+
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+	service := NewOrderService(mockRepo, cache)
+
+	mockRepo.On("StreamOrders", mock.Anything, warmUpChunkSize).
+		Return([][]*models.Order{}, errors.New("connection refused"))
+
+	// Unreconciled entries may be stale, so none are kept.
+	assert.Error(t, service.LoadCacheSnapshot(context.Background(), path))
+	assert.Equal(t, 0, cache.ItemsCount())
+}
+
+func TestOrderService_CacheOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+	service := NewOrderService(mockRepo, cache)
+
+	// test123 was created by this process and is already cached.
+	own := createValidOrder()
+	own.Version = 1
+	cache.Set(&own)
+	created := createValidOrder()
+	created.OrderUID = "test456"
+
+	mockRepo.On("GetOrdersByIDs", mock.MatchedBy(func(uids []string) bool {
+		return slices.Equal([]string{"gone", "test456"}, slices.Sorted(slices.Values(uids)))
+	})).Return([]*models.Order{&created}, nil)
+
+	err := service.CacheOrders(context.Background(), map[string]int{"test123": 1, "test456": 1, "gone": 1})
+
+	assert.NoError(t, err)
+	order, exists := cache.Get("test456")
+	assert.True(t, exists)
+	assert.Equal(t, &created, order)
+	assert.Equal(t, 2, cache.ItemsCount())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_RefreshOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+	service := NewOrderService(mockRepo, cache)
+
+	stale := createValidOrder()
+	cache.Set(&stale)
+	changed := createValidOrder()
+	changed.TrackNumber = "TRACK456"
+	changed.Version = 2
+
+	mockRepo.On("GetOrdersByIDs", []string{"test123"}).Return([]*models.Order{&changed}, nil)
+
+	assert.NoError(t, service.RefreshOrders(context.Background(), map[string]int{"test123": 2}))
+	order, _ := cache.Get("test123")
+	assert.Equal(t, "TRACK456", order.TrackNumber)
+
+	// The notification of its own update does not reload the order again.
+	assert.NoError(t, service.RefreshOrders(context.Background(), map[string]int{"test123": 2}))
+	mockRepo.AssertNumberOfCalls(t, "GetOrdersByIDs", 1)
+}
+
+func TestOrderService_RefreshOrders_Error(t *testing.T) {
+	mockRepo := new(MockRepository)
+	orderCache := cache.New(1024*1024, time.Minute)
+	service := NewOrderService(mockRepo, orderCache)
+
+	stale := createValidOrder()
+	orderCache.SetLookup(cache.ByTrackNumber, stale.TrackNumber, []*models.Order{&stale})
+
+	mockRepo.On("GetOrdersByIDs", mock.Anything).Return([]*models.Order{}, errors.New("connection refused"))
+
+	err := service.RefreshOrders(context.Background(), map[string]int{"test123": 2, "uncached": 1})
+	assert.ErrorIs(t, err, ErrStorage)
+
+	// The stale copy is not served until it expires, and the lookup it
+	// belonged to, or the uncached order may now belong to, is not complete.
+	_, exists := orderCache.Get("test123")
+	assert.False(t, exists)
+	_, ok := orderCache.Lookup(cache.ByTrackNumber, stale.TrackNumber)
+	assert.False(t, ok)
+}
+
+func TestOrderService_EvictOrders(t *testing.T) {
+	cache := cache.New(1024*1024, time.Minute)
+	service := NewOrderService(new(MockRepository), cache)
+
+	testOrder := createValidOrder()
+	cache.Set(&testOrder)
+
+	service.EvictOrders([]string{"test123", "unknown"})
+	assert.Equal(t, 0, cache.ItemsCount())
+}
+
+func TestOrderService_CreateOrder_StorageErrorIsTransient(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	testOrder := createValidOrder()
+
+	mockRepo.On("OrderExists", testOrder.OrderUID).Return(false, nil)
+	mockRepo.On("SaveOrder", &testOrder).Return(errors.New("connection refused"))
+
+	err := service.CreateOrder(context.Background(), &testOrder)
+	assert.Error(t, err)
+	assert.True(t, IsTransient(err))
+
+	err = service.CreateOrder(context.Background(), &models.Order{})
+	assert.Error(t, err)
+	assert.False(t, IsTransient(err))
+}
+
+func TestOrderService_ProcessOrderMessages(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	first := createValidOrder()
+	first.OrderUID = "order-1"
+	second := createValidOrder()
+	second.OrderUID = "order-2"
+	third := createValidOrder()
+	third.OrderUID = "order-3"
+
+	firstJSON, _ := json.Marshal(first)
+	secondJSON, _ := json.Marshal(second)
+	thirdJSON, _ := json.Marshal(third)
+
+	mockRepo.On("SaveOrders", mock.MatchedBy(func(orders []*models.Order) bool {
+		return len(orders) == 3
+	})).Return([]error{nil, repository.ErrOrderExists, errors.New("value too long")}, nil)
+	mockRepo.On("UpdateOrder", mock.MatchedBy(func(order *models.Order) bool {
+		return order.OrderUID == "order-2"
+	})).Return(repository.ErrStaleVersion)
+
+	errs := service.ProcessOrderMessages(context.Background(), [][]byte{firstJSON, []byte("{"), secondJSON, thirdJSON})
+	assert.Len(t, errs, 4)
+	assert.NoError(t, errs[0])
+	assert.Contains(t, errs[1].Error(), "error parsing message")
+	assert.True(t, IsAlreadyApplied(errs[2]))
+	assert.Error(t, errs[3])
+	assert.False(t, IsTransient(errs[3]))
+
+	_, exists := cache.Get("order-1")
+	assert.True(t, exists)
+	_, exists = cache.Get("order-3")
+	assert.False(t, exists)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_ProcessOrderMessages_DuplicateInBatch(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	// A correction arrives in the same batch as the order, and a redelivery
+	// of the correction after it.
+	order := createValidOrder()
+	order.Version = 1
+	correction := order
+	correction.Version = 2
+	correction.TrackNumber = "CORRECTED"
+	messages := make([][]byte, 0, 3)
+	for _, o := range []models.Order{order, correction, order} {
+		message, _ := json.Marshal(o)
+		messages = append(messages, message)
+	}
+
+	mockRepo.On("SaveOrders", mock.Anything).
+		Return([]error{nil, repository.ErrOrderExists, repository.ErrOrderExists}, nil)
+	mockRepo.On("UpdateOrder", mock.MatchedBy(func(o *models.Order) bool { return o.Version == 2 })).Return(nil).Once()
+	mockRepo.On("UpdateOrder", mock.MatchedBy(func(o *models.Order) bool { return o.Version == 1 })).Return(repository.ErrStaleVersion).Once()
+
+	errs := service.ProcessOrderMessages(context.Background(), messages)
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.True(t, IsAlreadyApplied(errs[2]))
+
+	cached, exists := cache.Get(order.OrderUID)
+	if assert.True(t, exists) {
+		assert.Equal(t, "CORRECTED", cached.TrackNumber)
+	}
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_ProcessOrderMessages_TransactionFails(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	order := createValidOrder()
+	orderJSON, _ := json.Marshal(order)
+
+	mockRepo.On("SaveOrders", mock.Anything).Return(nil, errors.New("connection reset"))
+
+	errs := service.ProcessOrderMessages(context.Background(), [][]byte{orderJSON, []byte("{")})
+	assert.True(t, IsTransient(errs[0]))
+	assert.False(t, IsTransient(errs[1]))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_UpdateOrder(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	stored := createValidOrder()
+	stored.Version = 1
+	cache.Set(&stored)
+
+	update := createValidOrder()
+	update.Version = 2
+	update.Delivery.City = "Haifa"
+
+	mockRepo.On("UpdateOrder", &update).Return(nil)
+
+	err := service.UpdateOrder(context.Background(), &update)
+	assert.NoError(t, err)
+
+	cached, exists := cache.Get(update.OrderUID)
+	assert.True(t, exists)
+	assert.Equal(t, "Haifa", cached.Delivery.City)
+	assert.Equal(t, 2, cached.Version)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_UpdateOrder_Errors(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	missingVersion := createValidOrder()
+	err := service.UpdateOrder(context.Background(), &missingVersion)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "validation error")
+
+	stale := createValidOrder()
+	stale.OrderUID = "stale"
+	stale.Version = 1
+	mockRepo.On("UpdateOrder", &stale).Return(repository.ErrStaleVersion)
+	err = service.UpdateOrder(context.Background(), &stale)
+	assert.ErrorIs(t, err, ErrStaleVersion)
+
+	unknown := createValidOrder()
+	unknown.OrderUID = "unknown"
+	unknown.Version = 3
+	mockRepo.On("UpdateOrder", &unknown).Return(repository.ErrOrderNotFound)
+	err = service.UpdateOrder(context.Background(), &unknown)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, exists := cache.Get("stale")
+	assert.False(t, exists)
+	mockRepo.AssertNotCalled(t, "UpdateOrder", &missingVersion)
+}
+
+func TestOrderService_ProcessOrderMessage_UpdatesExistingOrder(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	order := createValidOrder()
+	order.Version = 2
+	orderJSON, _ := json.Marshal(order)
+
+	mockRepo.On("OrderExists", order.OrderUID).Return(true, nil)
+	mockRepo.On("UpdateOrder", mock.AnythingOfType("*models.Order")).Return(nil).Once()
+
+	err := service.ProcessOrderMessage(context.Background(), orderJSON)
+	assert.NoError(t, err)
+
+	mockRepo.On("UpdateOrder", mock.AnythingOfType("*models.Order")).Return(repository.ErrStaleVersion)
+
+	err = service.ProcessOrderMessage(context.Background(), orderJSON)
+	assert.ErrorIs(t, err, ErrStaleVersion)
+	assert.True(t, IsAlreadyApplied(err))
+	mockRepo.AssertNotCalled(t, "SaveOrder", mock.Anything)
+}
+
+func TestOrderService_ListOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	var orders []*models.Order
+	for i := 0; i < 3; i++ {
+		order := createValidOrder()
+		order.OrderUID = fmt.Sprintf("order-%d", i)
+		order.DateCreated = base.Add(-time.Duration(i) * time.Hour)
+		orders = append(orders, &order)
+	}
+
+	mockRepo.On("ListOrders", repository.OrderFilter{CustomerID: "test_customer", Limit: 3}).
+		Return(orders, nil).Once()
+
+	page, err := service.ListOrders(context.Background(), repository.OrderFilter{CustomerID: "test_customer"}, "", 2)
+	assert.NoError(t, err)
+	assert.Len(t, page.Orders, 2)
+	assert.NotEmpty(t, page.NextCursor)
+
+	mockRepo.On("ListOrders", repository.OrderFilter{
+		CustomerID: "test_customer",
+		AfterDate:  orders[1].DateCreated,
+		AfterUID:   "order-1",
+		Limit:      3,
+	}).Return(orders[2:], nil).Once()
+
+	page, err = service.ListOrders(context.Background(), repository.OrderFilter{CustomerID: "test_customer"}, page.NextCursor, 2)
+	assert.NoError(t, err)
+	assert.Len(t, page.Orders, 1)
+	assert.Equal(t, "order-2", page.Orders[0].OrderUID)
+	assert.Empty(t, page.NextCursor)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_ListOrders_InvalidCursor(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	_, err := service.ListOrders(context.Background(), repository.OrderFilter{}, "not a cursor", 10)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "validation error")
+	mockRepo.AssertNotCalled(t, "ListOrders", mock.Anything)
+}
+
+func TestOrderService_GetOrdersByTrackNumber(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	order := createValidOrder()
+	mockRepo.On("FindOrdersByTrackNumber", "TRACK123").Return([]*models.Order{&order}, nil).Once()
+
+	orders, err := service.GetOrdersByTrackNumber(context.Background(), "TRACK123")
+	assert.NoError(t, err)
+	assert.Len(t, orders, 1)
+
+	// The second lookup is served from the cache index.
+	orders, err = service.GetOrdersByTrackNumber(context.Background(), "TRACK123")
+	assert.NoError(t, err)
+	assert.Len(t, orders, 1)
+	assert.Equal(t, order.OrderUID, orders[0].OrderUID)
+
+	// A new order with the same track number joins the cached result.
+	another := createValidOrder()
+	another.OrderUID = "test456"
+	cache.Set(&another)
+
+	orders, err = service.GetOrdersByTrackNumber(context.Background(), "TRACK123")
+	assert.NoError(t, err)
+	assert.Len(t, orders, 2)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOrderService_GetOrdersByItem(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cache := cache.New(1024*1024, time.Minute)
+
+	service := NewOrderService(mockRepo, cache)
+
+	order := createValidOrder()
+	item := order.Items[0]
+	mockRepo.On("FindOrdersByChrtID", item.ChrtID).Return([]*models.Order{&order}, nil).Once()
+	mockRepo.On("FindOrdersByNmID", item.NmID).Return(nil, nil).Once()
+	mockRepo.On("FindOrdersByItemTrackNumber", "unknown").Return(nil, errors.New("connection refused")).Once()
+
+	orders, err := service.GetOrdersByChrtID(context.Background(), item.ChrtID)
+	assert.NoError(t, err)
+	assert.Len(t, orders, 1)
+
+	orders, err = service.GetOrdersByChrtID(context.Background(), item.ChrtID)
+	assert.NoError(t, err)
+	assert.Len(t, orders, 1)
+
+	// The nm_id index of the cached order is not known to be complete.
+	orders, err = service.GetOrdersByNmID(context.Background(), item.NmID)
+	assert.NoError(t, err)
+	assert.Empty(t, orders)
+	assert.NotNil(t, orders)
+
+	_, err = service.GetOrdersByItemTrackNumber(context.Background(), "unknown")
+	assert.True(t, IsTransient(err))
+
 	mockRepo.AssertExpectations(t)
 }
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// reconcileMargin widens the window of orders refreshed after loading a
+// snapshot. updated_at is the time a row was written, so a transaction
+// that committed after the snapshot may carry an earlier one; the margin
+// covers that delay, which DB_WRITE_TIMEOUT_MS bounds, and must stay above
+// it.
+const reconcileMargin = time.Minute
+
+// LoadCacheSnapshot fills the cache from the snapshot file at path and then
+// refreshes the cached orders that were changed in the database since the
+// snapshot was taken.
+func (s *OrderService) LoadCacheSnapshot(ctx context.Context, path string) error {
+	savedAt, err := s.cache.LoadSnapshot(path)
+	if err != nil {
+		return fmt.Errorf("error loading cache snapshot: %w", err)
+	}
+
+	// savedAt is measured by the application clock, while updated_at is set
+	// by the database. The newest updated_at in the snapshot is on the
+	// database clock, so starting from the earlier of the two is safe
+	// whichever clock is ahead.
+	since := savedAt
+	if latest := s.cache.LatestUpdate(); !latest.IsZero() && latest.Before(since) {
+		since = latest
+	}
+
+	if err := s.ReconcileCache(ctx, since.Add(-reconcileMargin)); err != nil {
+		return fmt.Errorf("error reconciling cache snapshot: %v", err)
+	}
+
+	return nil
+}
+
+// RunCacheSnapshots saves the cache to the snapshot file at path every
+// interval, and once more when ctx is done. A zero interval saves only on
+// shutdown. Failed saves are passed to onError.
+func (s *OrderService) RunCacheSnapshots(ctx context.Context, path string, interval time.Duration, onError func(error)) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			if err := s.cache.SaveSnapshot(path); err != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			if err := s.cache.SaveSnapshot(path); err != nil {
+				onError(err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"L0/internal/iso"
+	models "L0/internal/model"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Validation error codes.
+const (
+	CodeRequired      = "required"
+	CodeTooLong       = "too_long"
+	CodeNotPositive   = "not_positive"
+	CodeNegative      = "negative"
+	CodeInFuture      = "in_future"
+	CodeInvalidFormat = "invalid_format"
+	CodeMismatch      = "mismatch"
+	CodeNotAllowed    = "not_allowed"
+	CodeOutOfRange    = "out_of_range"
+	CodeTooPrecise    = "too_precise"
+)
+
+// ValidationError reports an invalid value of an order or request. Field
+// is the JSON path of the value, such as "delivery.phone" or
+// "items[2].price", and Code is one of the Code constants.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("validation error: %s: %s", e.Field, e.Message)
+}
+
+func invalid(field, code, message string) *ValidationError {
+	return &ValidationError{Field: field, Code: code, Message: message}
+}
+
+// ValidationErrors holds every violation found in an order.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	parts := make([]string, len(e))
+	for i, verr := range e {
+		parts[i] = verr.Field + ": " + verr.Message
+	}
+	return "validation error: " + strings.Join(parts, "; ")
+}
+
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, verr := range e {
+		errs[i] = verr
+	}
+	return errs
+}
+
+// IsValidation reports whether err is caused by invalid input.
+func IsValidation(err error) bool {
+	var verr *ValidationError
+	return errors.As(err, &verr)
+}
+
+// Violations returns the validation errors err carries, or nil if it is
+// not a validation error.
+func Violations(err error) ValidationErrors {
+	var verrs ValidationErrors
+	if errors.As(err, &verrs) {
+		return verrs
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return ValidationErrors{verr}
+	}
+	return nil
+}
+
+// EncodeViolations returns the validation errors err carries as a JSON
+// array, or nil if it is not a validation error.
+func EncodeViolations(err error) []byte {
+	verrs := Violations(err)
+	if verrs == nil {
+		return nil
+	}
+	data, _ := json.Marshal(verrs)
+	return data
+}
+
+// validator collects the violations of the checks run on an order.
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) add(field, code, message string) {
+	v.errs = append(v.errs, invalid(field, code, message))
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// validateOrder checks every field of order against the field rules and,
+// once they are valid, the consistency rules. It returns all violations as
+// ValidationErrors and replaces the warnings of the order with those of the
+// warn rules.
+func (s *OrderService) validateOrder(order *models.Order) error {
+	var v validator
+	order.Warnings = nil
+	rules := s.fields.Load()
+
+	value := reflect.ValueOf(order).Elem()
+	for i := range rules.order {
+		rules.order[i].check(value, "", &v)
+	}
+
+	if order.DateCreated.IsZero() {
+		v.add("date_created", CodeRequired, "is required")
+	} else if order.DateCreated.After(time.Now().Add(24 * time.Hour)) {
+		v.add("date_created", CodeInFuture, "cannot be in the future")
+	}
+
+	if len(order.Items) == 0 {
+		v.add("items", CodeRequired, "must have at least one item")
+	}
+
+	for i := range order.Items {
+		value := reflect.ValueOf(&order.Items[i]).Elem()
+		prefix := fmt.Sprintf("items[%d].", i)
+		for j := range rules.items {
+			rules.items[j].check(value, prefix, &v)
+		}
+	}
+
+	checkMinorUnits(order, &v)
+
+	if len(v.errs) == 0 {
+		s.checkConsistency(order, &v)
+	}
+
+	return v.err()
+}
+
+// checkMinorUnits rejects amounts with more decimals than the minor unit of
+// the payment currency allows, such as cents of a yen. Orders in an unknown
+// currency are left to the field rules.
+func checkMinorUnits(order *models.Order, v *validator) {
+	currency := order.Payment.Currency
+	digits, ok := iso.MinorUnits(currency)
+	if !ok {
+		return
+	}
+
+	unit := models.Money(math.Pow10(models.MoneyDigits - digits))
+	check := func(field string, amount models.Money) {
+		if amount%unit != 0 {
+			v.add(field, CodeTooPrecise, fmt.Sprintf("must have at most %d decimal places in %s", digits, currency))
+		}
+	}
+
+	p := order.Payment
+	check("payment.amount", p.Amount)
+	check("payment.delivery_cost", p.DeliveryCost)
+	check("payment.goods_total", p.GoodsTotal)
+	check("payment.custom_fee", p.CustomFee)
+	for i, item := range order.Items {
+		check(fmt.Sprintf("items[%d].price", i), item.Price)
+		check(fmt.Sprintf("items[%d].total_price", i), item.TotalPrice)
+	}
+}
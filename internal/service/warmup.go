@@ -0,0 +1,76 @@
+package service
+
+import (
+	models "L0/internal/model"
+	"L0/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// warmUpChunkSize is the number of orders loaded per query when the cache
+// is warmed up from the database.
+const warmUpChunkSize = 500
+
+// errWarmUpDone stops streaming once the cache budget or the order limit is
+// reached.
+var errWarmUpDone = errors.New("warm-up done")
+
+// WarmUpOptions bounds the cache warm-up. MaxOrders of zero means no limit
+// other than the cache size.
+type WarmUpOptions struct {
+	MaxOrders int
+	HotSet    []string
+}
+
+// WarmUpCache loads the hot set and then the most recent orders into the
+// cache until the cache budget or opts.MaxOrders is reached. The budget
+// counts as reached once none of the orders of a chunk fit any more; an
+// order too large for the space left does not stop smaller ones after it.
+// Nothing already cached is evicted.
+func (s *OrderService) WarmUpCache(ctx context.Context, opts WarmUpOptions) error {
+	loaded := 0
+	hot := make(map[string]bool, len(opts.HotSet))
+
+	// restore adds the orders that fit and reports whether to go on. Orders
+	// already cached in a newer version do not count as loaded.
+	restore := func(orders []*models.Order) bool {
+		if opts.MaxOrders > 0 && loaded+len(orders) > opts.MaxOrders {
+			orders = orders[:opts.MaxOrders-loaded]
+		}
+		added, all := s.cache.Restore(orders)
+		loaded += added
+		return (all || added > 0) && (opts.MaxOrders == 0 || loaded < opts.MaxOrders)
+	}
+
+	if len(opts.HotSet) > 0 {
+		orders, err := s.repo.GetOrdersByIDs(ctx, opts.HotSet)
+		if err != nil {
+			return fmt.Errorf("error loading hot orders from DB: %v", err)
+		}
+		for _, order := range orders {
+			hot[order.OrderUID] = true
+		}
+		if !restore(orders) {
+			return nil
+		}
+	}
+
+	err := s.repo.StreamOrders(ctx, repository.OrderFilter{}, warmUpChunkSize, func(orders []*models.Order) error {
+		recent := make([]*models.Order, 0, len(orders))
+		for _, order := range orders {
+			if !hot[order.OrderUID] {
+				recent = append(recent, order)
+			}
+		}
+		if !restore(recent) {
+			return errWarmUpDone
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errWarmUpDone) {
+		return fmt.Errorf("error loading orders from DB: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+GRANT DELETE ON deliveries, payments, items TO app_user_2;
+
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- +goose StatementEnd
+
+-- +goose Down
+REVOKE DELETE ON deliveries, payments, items FROM app_user_2;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS version;
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS idx_items_track_number ON items(track_number);
+CREATE INDEX IF NOT EXISTS idx_items_nm_id ON items(nm_id);
+
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- +goose StatementEnd
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_items_nm_id;
+DROP INDEX IF EXISTS idx_items_track_number;
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
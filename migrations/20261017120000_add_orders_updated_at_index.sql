@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS idx_orders_updated_at ON orders(updated_at);
+
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- +goose StatementEnd
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_orders_updated_at;
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_order_change() RETURNS trigger AS $$
+DECLARE
+    changed orders%ROWTYPE;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed := OLD;
+    ELSE
+        changed := NEW;
+    END IF;
+
+    PERFORM pg_notify('order_changes', json_build_object(
+        'op', TG_OP,
+        'order_uid', changed.order_uid,
+        'version', changed.version
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS orders_notify_change ON orders;
+CREATE TRIGGER orders_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON orders
+    FOR EACH ROW EXECUTE FUNCTION notify_order_change();
+
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS orders_notify_change ON orders;
+DROP FUNCTION IF EXISTS notify_order_change();
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
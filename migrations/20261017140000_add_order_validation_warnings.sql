@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS validation_warnings JSONB NOT NULL DEFAULT '[]';
+
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE orders DROP COLUMN IF EXISTS validation_warnings;
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
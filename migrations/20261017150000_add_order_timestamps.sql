@@ -0,0 +1,38 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE;
+
+-- The time an existing order was stored is not known. date_created is the
+-- closest estimate; updated_at may be the time of a later update.
+UPDATE orders SET updated_at = COALESCE(updated_at, date_created, CURRENT_TIMESTAMP) WHERE updated_at IS NULL;
+UPDATE orders SET created_at = COALESCE(date_created, updated_at) WHERE created_at IS NULL;
+
+ALTER TABLE orders ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE orders ALTER COLUMN created_at SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN updated_at SET NOT NULL;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_order_timestamps() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.created_at := CURRENT_TIMESTAMP;
+    ELSE
+        NEW.created_at := OLD.created_at;
+    END IF;
+    NEW.updated_at := CURRENT_TIMESTAMP;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS orders_set_timestamps ON orders;
+CREATE TRIGGER orders_set_timestamps
+    BEFORE INSERT OR UPDATE ON orders
+    FOR EACH ROW EXECUTE FUNCTION set_order_timestamps();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS orders_set_timestamps ON orders;
+DROP FUNCTION IF EXISTS set_order_timestamps();
+
+ALTER TABLE orders ALTER COLUMN updated_at DROP NOT NULL;
+ALTER TABLE orders DROP COLUMN IF EXISTS created_at;
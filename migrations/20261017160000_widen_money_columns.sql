@@ -0,0 +1,22 @@
+-- +goose Up
+-- Money keeps four decimal places, the largest minor unit of any currency.
+ALTER TABLE payments
+    ALTER COLUMN amount TYPE NUMERIC(19,4),
+    ALTER COLUMN delivery_cost TYPE NUMERIC(19,4),
+    ALTER COLUMN goods_total TYPE NUMERIC(19,4),
+    ALTER COLUMN custom_fee TYPE NUMERIC(19,4);
+
+ALTER TABLE items
+    ALTER COLUMN price TYPE NUMERIC(19,4),
+    ALTER COLUMN total_price TYPE NUMERIC(19,4);
+
+-- +goose Down
+ALTER TABLE items
+    ALTER COLUMN price TYPE DECIMAL(15,2),
+    ALTER COLUMN total_price TYPE DECIMAL(15,2);
+
+ALTER TABLE payments
+    ALTER COLUMN amount TYPE DECIMAL(15,2),
+    ALTER COLUMN delivery_cost TYPE DECIMAL(15,2),
+    ALTER COLUMN goods_total TYPE DECIMAL(15,2),
+    ALTER COLUMN custom_fee TYPE DECIMAL(15,2);
@@ -0,0 +1,34 @@
+-- +goose Up
+-- CURRENT_TIMESTAMP is the start of the transaction, which may be long
+-- before it commits. clock_timestamp() is the time the row is written, so
+-- updated_at lags behind the commit only by the rest of the transaction.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_order_timestamps() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.created_at := clock_timestamp();
+    ELSE
+        NEW.created_at := OLD.created_at;
+    END IF;
+    NEW.updated_at := clock_timestamp();
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_order_timestamps() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        NEW.created_at := CURRENT_TIMESTAMP;
+    ELSE
+        NEW.created_at := OLD.created_at;
+    END IF;
+    NEW.updated_at := CURRENT_TIMESTAMP;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
@@ -4,8 +4,16 @@ import "errors"
 
 // ErrorResponse represents standard error response structure.
 type ErrorResponse struct {
-	Error   bool   `json:"error" example:"true"`
-	Message string `json:"message" example:"error description"`
+	Error   bool         `json:"error" example:"true"`
+	Message string       `json:"message" example:"error description"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes one invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field" example:"items[3].price"`
+	Code    string `json:"code" example:"not_positive"`
+	Message string `json:"message" example:"must be positive"`
 }
 
 var (
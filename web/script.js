@@ -57,6 +57,8 @@ document.addEventListener('DOMContentLoaded', function() {
                 <p><strong>Customer ID:</strong> ${order.customer_id}</p>
                 <p><strong>Delivery Service:</strong> ${order.delivery_service}</p>
                 <p><strong>Date Created:</strong> ${new Date(order.date_created).toLocaleString()}</p>
+                <p><strong>Stored:</strong> ${new Date(order.created_at).toLocaleString()}</p>
+                <p><strong>Last Updated:</strong> ${new Date(order.updated_at).toLocaleString()}</p>
             </div>
             
             <div class="section">
@@ -199,7 +201,7 @@ document.addEventListener('DOMContentLoaded', function() {
                 } else {
                     return response.json().then(err => {
                         throw new Error(err.message || 'Failed to create order');
-                    }).catch(e => {
+                    }, () => {
                         throw new Error('Server error: ' + response.status);
                     });
                 }